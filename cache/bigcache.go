@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"lxi/cache/bigcache"
+	"sync/atomic"
+)
+
+type bigCache struct {
+	c *bigcache.BigCache
+
+	getCalls uint64
+	setCalls uint64
+	misses   uint64
+}
+
+// NewBigCache returns Cache backed by bigcache.BigCache with the given config.
+func NewBigCache(ctx context.Context, config bigcache.Config) Cache {
+	return WrapBigCache(bigcache.NewBigCacheWithConfig(ctx, config))
+}
+
+// WrapBigCache returns Cache backed by c.
+func WrapBigCache(c *bigcache.BigCache) Cache {
+	return &bigCache{c: c}
+}
+
+func (bc *bigCache) Get(dst, k []byte) []byte {
+	atomic.AddUint64(&bc.getCalls, 1)
+	v, err := bc.c.Get(k)
+	if err != nil {
+		atomic.AddUint64(&bc.misses, 1)
+		return dst
+	}
+	return append(dst, v...)
+}
+
+func (bc *bigCache) Set(k, v []byte) {
+	atomic.AddUint64(&bc.setCalls, 1)
+	// Too big entries are dropped like in fastcache.
+	_ = bc.c.Set(k, v)
+}
+
+func (bc *bigCache) Del(k []byte) {
+	_ = bc.c.Delete(k)
+}
+
+func (bc *bigCache) Has(k []byte) bool {
+	atomic.AddUint64(&bc.getCalls, 1)
+	if _, err := bc.c.Get(k); err != nil {
+		atomic.AddUint64(&bc.misses, 1)
+		return false
+	}
+	return true
+}
+
+func (bc *bigCache) Reset() {
+	bc.c.Reset()
+	atomic.StoreUint64(&bc.getCalls, 0)
+	atomic.StoreUint64(&bc.setCalls, 0)
+	atomic.StoreUint64(&bc.misses, 0)
+}
+
+func (bc *bigCache) Stats() Stats {
+	return Stats{
+		GetCalls:     atomic.LoadUint64(&bc.getCalls),
+		SetCalls:     atomic.LoadUint64(&bc.setCalls),
+		Misses:       atomic.LoadUint64(&bc.misses),
+		EntriesCount: uint64(bc.c.Len()),
+		BytesSize:    uint64(bc.c.Capacity()),
+	}
+}
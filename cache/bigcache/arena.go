@@ -0,0 +1,22 @@
+package bigcache
+
+// allocator provides the memory for BytesQueue entries.
+type allocator interface {
+	alloc(size int) []byte
+	free(b []byte)
+}
+
+type heapAllocator struct{}
+
+func (heapAllocator) alloc(size int) []byte {
+	return make([]byte, size)
+}
+
+func (heapAllocator) free(b []byte) {}
+
+func newAllocator(offHeap bool) allocator {
+	if offHeap {
+		return offHeapAllocator{}
+	}
+	return heapAllocator{}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package bigcache
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// offHeapAllocator allocates the memory with anonymous mmap outside the Go heap,
+// so the GC neither scans it nor counts it towards the heap size.
+type offHeapAllocator struct{}
+
+func (offHeapAllocator) alloc(size int) []byte {
+	b, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		panic(fmt.Errorf("cannot mmap %d bytes: %w", size, err))
+	}
+	return b
+}
+
+func (offHeapAllocator) free(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	if err := syscall.Munmap(b); err != nil {
+		panic(fmt.Errorf("cannot munmap %d bytes: %w", len(b), err))
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package bigcache
+
+// offHeapAllocator falls back to the Go heap on platforms without anonymous mmap support.
+type offHeapAllocator = heapAllocator
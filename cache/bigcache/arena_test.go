@@ -0,0 +1,78 @@
+package bigcache
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var arenaEntries = flag.Int("bigcache.arenaEntries", 20e6, "The number of entries to store in BenchmarkHeapBuffers and BenchmarkOffHeapBuffers")
+
+func TestOffHeapBigCache(t *testing.T) {
+	c := NewBigCacheWithConfig(context.Background(), Config{
+		InitialShardSize: 1024,
+		OffHeap:          true,
+	})
+	defer c.Close()
+
+	k := make([]byte, 8)
+	for i := 0; i < 1e5; i++ {
+		binary.BigEndian.PutUint64(k, uint64(i))
+		if err := c.Set(k, k); err != nil {
+			t.Fatalf("cannot set entry #%d: %s", i, err)
+		}
+	}
+	n := 0
+	for _, s := range c.shards {
+		n += s.data.Len()
+	}
+	if n != 1e5 {
+		t.Fatalf("unexpected number of entries; got %d; want %d", n, int(1e5))
+	}
+}
+
+func BenchmarkHeapBuffers(b *testing.B) {
+	benchmarkBuffersGC(b, false)
+}
+
+func BenchmarkOffHeapBuffers(b *testing.B) {
+	benchmarkBuffersGC(b, true)
+}
+
+// benchmarkBuffersGC fills the cache with *arenaEntries entries and measures the GC pause and the heap size.
+func benchmarkBuffersGC(b *testing.B, offHeap bool) {
+	c := NewBigCacheWithConfig(context.Background(), Config{
+		LifeWindow:  time.Hour,
+		CleanWindow: time.Hour,
+		OffHeap:     offHeap,
+	})
+	defer c.Close()
+
+	k := make([]byte, 8)
+	v := make([]byte, 32)
+	for i := 0; i < *arenaEntries; i++ {
+		binary.BigEndian.PutUint64(k, uint64(i))
+		if err := c.Set(k, v); err != nil {
+			b.Fatalf("cannot set entry #%d: %s", i, err)
+		}
+	}
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	pauseNs := after.PauseTotalNs - before.PauseTotalNs
+	b.ReportMetric(float64(pauseNs)/float64(after.NumGC-before.NumGC), "pause-ns/gc")
+	b.ReportMetric(float64(after.HeapAlloc)/(1<<20), "heap-MB")
+	b.ReportMetric(float64(after.HeapSys)/(1<<20), "heap-sys-MB")
+	runtime.KeepAlive(c)
+}
@@ -8,6 +8,10 @@ const (
 	ShardSize     = 64 * 1024
 	LeftMargin    = 1
 	MaxHeaderSize = 6
+
+	// minimumEntrySize is the size of the smallest entry written by warpEntry.
+	// Empty gaps are filled with entries of at least this size, so they can be read as an entry.
+	minimumEntrySize = 1 + timestampLen + hashLen + keySizeLen
 )
 
 type BytesQueue struct {
@@ -17,38 +21,99 @@ type BytesQueue struct {
 	tail        int
 	count       int
 	rightMargin int
+	full        bool
+
+	capacity    int
+	maxCapacity int
+	alloc       allocator
 }
 
 var (
 	emptyError = &QueueError{message: "queue is empty"}
+	fullError  = &QueueError{message: "queue is full; maximum size limit reached"}
 )
 
 func NewBytesQueue() *BytesQueue {
+	return newBytesQueue(ShardSize, 0, heapAllocator{})
+}
+
+// newBytesQueue creates a queue with entries obtained from alloc.
+// The queue grows up to maxCapacity bytes; zero maxCapacity means no limit.
+func newBytesQueue(capacity, maxCapacity int, alloc allocator) *BytesQueue {
+	if maxCapacity > 0 && capacity > maxCapacity {
+		capacity = maxCapacity
+	}
 	return &BytesQueue{
-		entries:     make([]byte, ShardSize),
+		entries:     alloc.alloc(capacity),
 		headBuffer:  make([]byte, MaxHeaderSize),
 		head:        LeftMargin,
 		tail:        LeftMargin,
 		rightMargin: LeftMargin,
+		capacity:    capacity,
+		maxCapacity: maxCapacity,
+		alloc:       alloc,
 	}
 }
 
-func (q *BytesQueue) Push(data []byte) int {
+func (q *BytesQueue) Push(data []byte) (int, error) {
 	needSize := getNeedSize(len(data))
-	return q.push(data, needSize)
 
+	if !q.canInsertAfterTail(needSize) {
+		if q.canInsertBeforeHead(needSize) {
+			q.tail = LeftMargin
+		} else if q.maxCapacity > 0 && q.capacity+needSize >= q.maxCapacity {
+			return -1, fullError
+		} else {
+			q.grow(needSize)
+		}
+	}
+
+	index := q.tail
+	q.push(data, needSize)
+	return index, nil
 }
 
-func (q *BytesQueue) push(data []byte, needSize int) int {
+func (q *BytesQueue) push(data []byte, needSize int) {
 	headLength := binary.PutUvarint(q.headBuffer, uint64(needSize))
-	index := q.tail
 	q.copy(q.headBuffer, headLength)
 	q.copy(data, needSize-headLength)
 	if q.tail > q.head {
 		q.rightMargin = q.tail
 	}
+	if q.tail == q.head {
+		q.full = true
+	}
 	q.count += 1
-	return index
+}
+
+// grow re-allocates the entries, so they have room for at least minimum more bytes.
+// Offsets of the existing entries are kept, since they are referenced by the shard index.
+func (q *BytesQueue) grow(minimum int) {
+	if q.capacity < minimum {
+		q.capacity += minimum
+	}
+	q.capacity *= 2
+	if q.maxCapacity > 0 && q.capacity > q.maxCapacity {
+		q.capacity = q.maxCapacity
+	}
+
+	oldEntries := q.entries
+	q.entries = q.alloc.alloc(q.capacity)
+
+	if q.rightMargin != LeftMargin {
+		copy(q.entries, oldEntries[:q.rightMargin])
+		if q.tail <= q.head {
+			// The queue is wrapped. Fill the gap between tail and head with an empty entry
+			// and continue writing after the right margin.
+			if q.tail != q.head {
+				q.push(make([]byte, q.head-q.tail), q.head-q.tail)
+			}
+			q.head = LeftMargin
+			q.tail = q.rightMargin
+		}
+	}
+	q.full = false
+	q.alloc.free(oldEntries)
 }
 
 func (q *BytesQueue) Peek() ([]byte, int, error) {
@@ -62,6 +127,15 @@ func (q *BytesQueue) Pop() ([]byte, error) {
 	}
 	q.head += blockSize
 	q.count -= 1
+
+	if q.head == q.rightMargin {
+		q.head = LeftMargin
+		if q.tail == q.rightMargin {
+			q.tail = LeftMargin
+		}
+		q.rightMargin = q.tail
+	}
+	q.full = false
 	return data, nil
 }
 
@@ -81,6 +155,51 @@ func (q *BytesQueue) peekCheck() error {
 	return nil
 }
 
+func (q *BytesQueue) canInsertAfterTail(need int) bool {
+	if q.full {
+		return false
+	}
+	if q.tail >= q.head {
+		return q.capacity-q.tail >= need
+	}
+	// Leave either no gap or a gap big enough for an empty entry before the head,
+	// since grow fills the gap with an empty entry.
+	return q.head-q.tail == need || q.head-q.tail >= need+minimumEntrySize
+}
+
+func (q *BytesQueue) canInsertBeforeHead(need int) bool {
+	if q.full {
+		return false
+	}
+	if q.tail >= q.head {
+		return q.head-LeftMargin == need || q.head-LeftMargin >= need+minimumEntrySize
+	}
+	return q.head-q.tail == need || q.head-q.tail >= need+minimumEntrySize
+}
+
+func (q *BytesQueue) Len() int {
+	return q.count
+}
+
+func (q *BytesQueue) Capacity() int {
+	return q.capacity
+}
+
+func (q *BytesQueue) Reset() {
+	q.head = LeftMargin
+	q.tail = LeftMargin
+	q.rightMargin = LeftMargin
+	q.count = 0
+	q.full = false
+}
+
+// Release returns the entries to the allocator. The queue mustn't be used after that.
+func (q *BytesQueue) Release() {
+	q.alloc.free(q.entries)
+	q.entries = nil
+	q.Reset()
+}
+
 func (q *BytesQueue) copy(data []byte, length int) {
 	q.tail += copy(q.entries[q.tail:], data[:length])
 }
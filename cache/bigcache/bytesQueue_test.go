@@ -0,0 +1,141 @@
+package bigcache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBytesQueueWrap(t *testing.T) {
+	q := newBytesQueue(64, 0, heapAllocator{})
+	entry := bytes.Repeat([]byte("x"), 20)
+
+	// Fill the queue, free room at the beginning and push an entry, which must wrap.
+	for i := 0; i < 3; i++ {
+		if _, err := q.Push(entry); err != nil {
+			t.Fatalf("cannot push entry #%d: %s", i, err)
+		}
+	}
+	if _, err := q.Pop(); err != nil {
+		t.Fatalf("cannot pop: %s", err)
+	}
+	if _, err := q.Pop(); err != nil {
+		t.Fatalf("cannot pop: %s", err)
+	}
+	index, err := q.Push(entry)
+	if err != nil {
+		t.Fatalf("cannot push: %s", err)
+	}
+	if index != LeftMargin {
+		t.Fatalf("expecting the entry to wrap to %d; got index %d", LeftMargin, index)
+	}
+	if q.Capacity() != 64 {
+		t.Fatalf("unexpected capacity after wrap; got %d; want 64", q.Capacity())
+	}
+}
+
+func TestBytesQueueFull(t *testing.T) {
+	q := newBytesQueue(32, 64, heapAllocator{})
+	entry := bytes.Repeat([]byte("x"), 20)
+	for i := 0; i < 3; i++ {
+		if _, err := q.Push(entry); err != nil {
+			t.Fatalf("cannot push entry #%d: %s", i, err)
+		}
+	}
+	if _, err := q.Push(entry); !errors.Is(err, fullError) {
+		t.Fatalf("unexpected error; got %v; want %v", err, fullError)
+	}
+	if _, err := q.Pop(); err != nil {
+		t.Fatalf("cannot pop: %s", err)
+	}
+	if _, err := q.Push(entry); err != nil {
+		t.Fatalf("cannot push after pop: %s", err)
+	}
+}
+
+func TestBytesQueueEmpty(t *testing.T) {
+	q := NewBytesQueue()
+	if _, _, err := q.Peek(); !errors.Is(err, emptyError) {
+		t.Fatalf("unexpected Peek error; got %v; want %v", err, emptyError)
+	}
+	if _, err := q.Pop(); !errors.Is(err, emptyError) {
+		t.Fatalf("unexpected Pop error; got %v; want %v", err, emptyError)
+	}
+}
+
+// FuzzBytesQueue runs the sequence of pushes and pops encoded in ops
+// and verifies that every live entry can be read at its index.
+func FuzzBytesQueue(f *testing.F) {
+	f.Add([]byte{10, 20, 0, 30, 0, 0, 200, 5, 0, 40}, 64, 0)
+	f.Add([]byte{100, 100, 0, 100, 100, 0, 0, 100}, 128, 512)
+	f.Add([]byte{255, 255, 255, 0, 255, 0, 1, 1, 1}, 16, 0)
+	f.Fuzz(func(t *testing.T, ops []byte, capacity, maxCapacity int) {
+		if capacity <= LeftMargin || capacity > 1<<16 || maxCapacity < 0 || maxCapacity > 1<<16 {
+			t.Skip()
+		}
+		if maxCapacity > 0 && maxCapacity <= LeftMargin {
+			t.Skip()
+		}
+		q := newBytesQueue(capacity, maxCapacity, heapAllocator{})
+		live := make(map[int][]byte)
+		fillers := 0
+
+		for i, op := range ops {
+			if op == 0 {
+				index := q.head
+				data, err := q.Pop()
+				if len(live) == 0 && fillers == 0 {
+					if !errors.Is(err, emptyError) {
+						t.Fatalf("op #%d: unexpected error for empty queue: %v", i, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("op #%d: cannot pop: %s", i, err)
+				}
+				want, ok := live[index]
+				if !ok {
+					// Gap filler written by grow.
+					if !bytes.Equal(data, make([]byte, len(data))) {
+						t.Fatalf("op #%d: unexpected entry at index %d: %q", i, index, data)
+					}
+					fillers--
+					continue
+				}
+				if !bytes.Equal(data, want) {
+					t.Fatalf("op #%d: unexpected entry at index %d; got %q; want %q", i, index, data, want)
+				}
+				delete(live, index)
+				continue
+			}
+
+			data := bytes.Repeat([]byte{op}, int(op)*(i%7+1))
+			countBefore := q.Len()
+			index, err := q.Push(data)
+			if err != nil {
+				if !errors.Is(err, fullError) || maxCapacity == 0 {
+					t.Fatalf("op #%d: unexpected error: %v", i, err)
+				}
+				continue
+			}
+			fillers += q.Len() - countBefore - 1
+			if _, ok := live[index]; ok {
+				t.Fatalf("op #%d: index %d is already used by a live entry", i, index)
+			}
+			live[index] = data
+
+			for idx, want := range live {
+				got, _, err := q.peek(idx)
+				if err != nil {
+					t.Fatalf("op #%d: cannot peek index %d: %s", i, idx, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("op #%d: unexpected entry at index %d; got %q; want %q", i, idx, got, want)
+				}
+			}
+			if q.Len() != len(live)+fillers {
+				t.Fatalf("op #%d: unexpected queue length; got %d; want %d", i, q.Len(), len(live)+fillers)
+			}
+		}
+	})
+}
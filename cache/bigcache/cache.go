@@ -2,7 +2,7 @@ package bigcache
 
 import (
 	"context"
-	xxhash "github.com/cespare/xxhash/v2"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,22 +11,117 @@ const (
 	ShardMask   = 511
 	LifeWindow  = 30
 	CleanWindow = 5
+
+	CleanShardsPerTick  = 64
+	MaxEvictionsPerLock = 128
 )
 
+// Config holds the tunables of BigCache. Zero fields fall back to the defaults.
+type Config struct {
+	// LifeWindow is the time after which an entry may be evicted.
+	LifeWindow time.Duration
+	// CleanWindow is the time in which every shard is cleaned up once.
+	CleanWindow time.Duration
+	// CleanShardsPerTick is the number of shards cleaned up on every tick.
+	// The ticks are spread evenly across CleanWindow.
+	CleanShardsPerTick int
+	// MaxEvictionsPerLock bounds the number of entries evicted while the shard lock is held.
+	MaxEvictionsPerLock int
+
+	// InitialShardSize is the initial size in bytes of every shard buffer.
+	InitialShardSize int
+	// MaxShardSize limits the size in bytes of every shard buffer. Zero means no limit.
+	// The oldest entries are evicted when the limit is reached.
+	MaxShardSize int
+	// OffHeap allocates the shard buffers outside the Go heap with anonymous mmap.
+	// It is supported on Linux only. Close must be called in order to release the buffers.
+	OffHeap bool
+
+	// Hasher calculates the hashes of keys. XXHasher is used by default.
+	// Use NewRandomSeededHasher for keys coming from untrusted clients.
+	Hasher Hasher
+
+	// OnRemove is called with every entry evicted from the cache because of expiration or size limit.
+	// It is called under the shard lock, so it mustn't access the cache.
+	OnRemove func(k, v []byte)
+}
+
+func DefaultConfig() Config {
+	return Config{
+		LifeWindow:          time.Second * LifeWindow,
+		CleanWindow:         time.Second * CleanWindow,
+		CleanShardsPerTick:  CleanShardsPerTick,
+		MaxEvictionsPerLock: MaxEvictionsPerLock,
+		InitialShardSize:    ShardSize,
+		Hasher:              XXHasher{},
+	}
+}
+
+func (cfg *Config) normalize() {
+	def := DefaultConfig()
+	if cfg.LifeWindow <= 0 {
+		cfg.LifeWindow = def.LifeWindow
+	}
+	if cfg.CleanWindow <= 0 {
+		cfg.CleanWindow = def.CleanWindow
+	}
+	if cfg.CleanShardsPerTick <= 0 {
+		cfg.CleanShardsPerTick = def.CleanShardsPerTick
+	}
+	if cfg.CleanShardsPerTick > Shards {
+		cfg.CleanShardsPerTick = Shards
+	}
+	if cfg.MaxEvictionsPerLock <= 0 {
+		cfg.MaxEvictionsPerLock = def.MaxEvictionsPerLock
+	}
+	if cfg.InitialShardSize <= 0 {
+		cfg.InitialShardSize = def.InitialShardSize
+	}
+	if cfg.MaxShardSize < 0 {
+		cfg.MaxShardSize = 0
+	}
+	if cfg.Hasher == nil {
+		cfg.Hasher = def.Hasher
+	}
+}
+
+// cleanInterval returns the tick interval, so that all the shards are visited once per CleanWindow.
+func (cfg *Config) cleanInterval() time.Duration {
+	ticks := (Shards + cfg.CleanShardsPerTick - 1) / cfg.CleanShardsPerTick
+	interval := cfg.CleanWindow / time.Duration(ticks)
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
 type BigCache struct {
 	shards []*CacheShard
+	config Config
+	cancel context.CancelFunc
+
+	cleanIndex uint64
+	cleanStats cleanUpStats
 }
 
 func NewBigCache(ctx context.Context) *BigCache {
-	c := BigCache{}
+	return NewBigCacheWithConfig(ctx, DefaultConfig())
+}
+
+func NewBigCacheWithConfig(ctx context.Context, config Config) *BigCache {
+	config.normalize()
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := BigCache{config: config, cancel: cancel}
 	c.shards = make([]*CacheShard, Shards)
 	for i := 0; i < Shards; i++ {
-		c.shards[i] = c.shards[i].InitShard()
+		c.shards[i] = c.shards[i].InitShard(&c.config)
 	}
 
 	go func() {
+		ticker := time.NewTicker(c.config.cleanInterval())
+		defer ticker.Stop()
 		for {
-			ticker := time.NewTicker(time.Second * CleanWindow)
 			select {
 			case t := <-ticker.C:
 				c.ClearUp(t)
@@ -39,22 +134,129 @@ func NewBigCache(ctx context.Context) *BigCache {
 	return &c
 }
 
-func (c *BigCache) Set(k, v []byte) {
-	hashIndex := xxhash.Sum64(k)
+func (c *BigCache) Set(k, v []byte) error {
+	hashIndex := c.config.Hasher.Sum64(k)
+	shardIndex := hashIndex & ShardMask
+	return c.shards[shardIndex].Set(k, v, hashIndex)
+}
+
+func (c *BigCache) Get(k []byte) ([]byte, error) {
+	hashIndex := c.config.Hasher.Sum64(k)
 	shardIndex := hashIndex & ShardMask
-	c.shards[shardIndex].Set(k, v, hashIndex)
+	return c.shards[shardIndex].Get(k, hashIndex)
 }
 
-func (c *BigCache) Get(k []byte) {
-	hashIndex := xxhash.Sum64(k)
+func (c *BigCache) Delete(k []byte) error {
+	hashIndex := c.config.Hasher.Sum64(k)
 	shardIndex := hashIndex & ShardMask
-	c.shards[shardIndex].Get(k, hashIndex)
+	return c.shards[shardIndex].Delete(k, hashIndex)
+}
+
+// Range calls f for every entry in the cache. k and v may be retained by f.
+func (c *BigCache) Range(f func(k, v []byte)) {
+	for _, s := range c.shards {
+		s.Range(f)
+	}
+}
 
+// Reset removes all the entries from the cache without calling OnRemove.
+func (c *BigCache) Reset() {
+	for _, s := range c.shards {
+		s.Reset()
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *BigCache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Len()
+	}
+	return n
 }
 
+// Capacity returns the size in bytes of the shard buffers.
+func (c *BigCache) Capacity() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Capacity()
+	}
+	return n
+}
+
+// Close stops the cleanup and releases the shard buffers.
+// The cache mustn't be used after Close.
+func (c *BigCache) Close() {
+	c.cancel()
+	for _, s := range c.shards {
+		s.Release()
+	}
+}
+
+// ClearUp cleans up the next CleanShardsPerTick shards in round-robin order.
 func (c *BigCache) ClearUp(t time.Time) {
+	start := time.Now()
 
-	for i := 0; i < Shards; i++ {
-		c.shards[i].CleanUp(t)
+	n := c.config.CleanShardsPerTick
+	evicted := 0
+	for i := 0; i < n; i++ {
+		shardIndex := (atomic.AddUint64(&c.cleanIndex, 1) - 1) & ShardMask
+		evicted += c.shards[shardIndex].CleanUp(t)
+	}
+
+	c.cleanStats.update(n, evicted, time.Since(start))
+}
+
+// CleanUpStats returns the cleanup statistics collected since the cache creation.
+func (c *BigCache) CleanUpStats() CleanUpStats {
+	return c.cleanStats.load()
+}
+
+type CleanUpStats struct {
+	// Runs is the number of ClearUp calls.
+	Runs uint64
+	// ShardsCleaned is the number of shards visited by ClearUp.
+	ShardsCleaned uint64
+	// Evicted is the number of expired entries removed.
+	Evicted uint64
+	// TotalDuration is the time spent in ClearUp.
+	TotalDuration time.Duration
+	// LastDuration is the duration of the last ClearUp call.
+	LastDuration time.Duration
+	// MaxDuration is the duration of the slowest ClearUp call.
+	MaxDuration time.Duration
+}
+
+type cleanUpStats struct {
+	runs          uint64
+	shardsCleaned uint64
+	evicted       uint64
+	totalDuration int64
+	lastDuration  int64
+	maxDuration   int64
+}
+
+func (cs *cleanUpStats) update(shards, evicted int, d time.Duration) {
+	atomic.AddUint64(&cs.runs, 1)
+	atomic.AddUint64(&cs.shardsCleaned, uint64(shards))
+	atomic.AddUint64(&cs.evicted, uint64(evicted))
+	atomic.AddInt64(&cs.totalDuration, int64(d))
+	atomic.StoreInt64(&cs.lastDuration, int64(d))
+	for {
+		max := atomic.LoadInt64(&cs.maxDuration)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&cs.maxDuration, max, int64(d)) {
+			break
+		}
+	}
+}
+
+func (cs *cleanUpStats) load() CleanUpStats {
+	return CleanUpStats{
+		Runs:          atomic.LoadUint64(&cs.runs),
+		ShardsCleaned: atomic.LoadUint64(&cs.shardsCleaned),
+		Evicted:       atomic.LoadUint64(&cs.evicted),
+		TotalDuration: time.Duration(atomic.LoadInt64(&cs.totalDuration)),
+		LastDuration:  time.Duration(atomic.LoadInt64(&cs.lastDuration)),
+		MaxDuration:   time.Duration(atomic.LoadInt64(&cs.maxDuration)),
 	}
 }
@@ -1,11 +1,16 @@
 package bigcache
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 )
 
+var ErrEntryNotFound = errors.New("entry not found")
+
 const EntryCounts = 1024
 
 type CacheShard struct {
@@ -13,62 +18,135 @@ type CacheShard struct {
 	data        *BytesQueue
 	indexHash   map[uint64]int
 	entryBuffer []byte
+
+	lifeWindow          uint64
+	maxEvictionsPerLock int
+	onRemove            func(k, v []byte)
 }
 
-func (s *CacheShard) InitShard() *CacheShard {
+func (s *CacheShard) InitShard(config *Config) *CacheShard {
 
 	return &CacheShard{
-		data:        NewBytesQueue(),
-		indexHash:   make(map[uint64]int, EntryCounts),
-		entryBuffer: make([]byte, ShardSize),
+		data:                newBytesQueue(config.InitialShardSize, config.MaxShardSize, newAllocator(config.OffHeap)),
+		indexHash:           make(map[uint64]int, EntryCounts),
+		entryBuffer:         make([]byte, ShardSize),
+		lifeWindow:          uint64(config.LifeWindow / time.Second),
+		maxEvictionsPerLock: config.MaxEvictionsPerLock,
+		onRemove:            config.OnRemove,
 	}
 }
 
-func (s *CacheShard) Set(k, v []byte, hashIndex uint64) {
+func (s *CacheShard) Set(k, v []byte, hashIndex uint64) error {
+	if len(k) > math.MaxUint16 {
+		return fmt.Errorf("too long key; got %d bytes; mustn't exceed %d bytes", len(k), math.MaxUint16)
+	}
 	timeStamp := uint64(time.Now().Unix())
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	w := warpEntry(k, v, timeStamp, hashIndex, &s.entryBuffer)
-	index := s.data.Push(w)
-	s.indexHash[hashIndex] = index
+	for {
+		index, err := s.data.Push(w)
+		if err == nil {
+			s.indexHash[hashIndex] = index
+			return nil
+		}
+		// The shard is full. Make room by evicting the oldest entry.
+		if _, _, err := s.data.Peek(); err != nil {
+			return fmt.Errorf("cannot store entry with %d bytes: %w", len(w), fullError)
+		}
+		s.removeEvictedEntry()
+	}
 }
 
-func (s *CacheShard) Get(k []byte, hashIndex uint64) {
-
-	index := s.indexHash[hashIndex]
-	fmt.Println(index)
-	s.getWarpedEntry(index)
+func (s *CacheShard) Get(k []byte, hashIndex uint64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	index, ok := s.indexHash[hashIndex]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	return s.getWarpedEntry(k, index)
 }
 
-func (s *CacheShard) getWarpedEntry(index int) {
+func (s *CacheShard) getWarpedEntry(k []byte, index int) ([]byte, error) {
 	entry, _, err := s.data.peek(index)
 	if err != nil {
-		return
+		return nil, err
 	}
-	k, v, timeStamp, hashIndex := readEntry(entry)
-	fmt.Println(string(k))
-	fmt.Println(string(v))
-	fmt.Println(timeStamp)
-	fmt.Println(hashIndex)
+	entryKey, v, _, _ := readEntry(entry)
+	if !bytes.Equal(entryKey, k) {
+		// Hash collision with another key.
+		return nil, ErrEntryNotFound
+	}
+	return v, nil
 }
 
-func (s *CacheShard) CleanUp(t time.Time) {
+func (s *CacheShard) Delete(k []byte, hashIndex uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	index, ok := s.indexHash[hashIndex]
+	if !ok {
+		return ErrEntryNotFound
+	}
+	if _, err := s.getWarpedEntry(k, index); err != nil {
+		return err
+	}
+	// The entry stays in the queue until it is popped by CleanUp.
+	delete(s.indexHash, hashIndex)
+	return nil
+}
+
+// Range calls f for every entry in the shard under the shard read lock.
+func (s *CacheShard) Range(f func(k, v []byte)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, index := range s.indexHash {
+		entry, _, err := s.data.peek(index)
+		if err != nil {
+			return
+		}
+		k, v, _, _ := readEntry(entry)
+		f(k, v)
+	}
+}
+
+// CleanUp removes the expired entries and returns their number.
+// The shard lock is released after every maxEvictionsPerLock evictions,
+// so Set and Get aren't blocked for the whole cleanup.
+func (s *CacheShard) CleanUp(t time.Time) int {
+	evicted := 0
 	for {
+		n, done := s.cleanUpLocked(t)
+		evicted += n
+		if done {
+			return evicted
+		}
+	}
+}
+
+func (s *CacheShard) cleanUpLocked(t time.Time) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < s.maxEvictionsPerLock; i++ {
 		if oldestEntry, _, err := s.data.Peek(); err != nil {
-			break
+			return i, true
 		} else if !s.onEvict(oldestEntry, t, s.removeEvictedEntry) {
-			break
+			return i, true
 		}
 	}
+	return s.maxEvictionsPerLock, false
 }
 
 func (s *CacheShard) onEvict(entry []byte, t time.Time, f func()) bool {
 	timeStamp := readEntryTimestamp(entry)
-	if uint64(t.Unix())-timeStamp > LifeWindow {
+	now := uint64(t.Unix())
+	if now > timeStamp && now-timeStamp > s.lifeWindow {
 		f()
 		return true
 	}
@@ -76,8 +154,45 @@ func (s *CacheShard) onEvict(entry []byte, t time.Time, f func()) bool {
 }
 
 func (s *CacheShard) removeEvictedEntry() {
+	index := s.data.head
 	entry, _ := s.data.Pop()
 	hash := readEntryHash(entry)
-	delete(s.indexHash, hash)
-	fmt.Println("clean up ", hash)
+	// The hash may point to a newer entry or belong to an empty gap entry.
+	if s.indexHash[hash] == index {
+		delete(s.indexHash, hash)
+		if s.onRemove != nil {
+			k, v, _, _ := readEntry(entry)
+			s.onRemove(k, v)
+		}
+	}
+}
+
+func (s *CacheShard) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Reset()
+	s.indexHash = make(map[uint64]int, EntryCounts)
+}
+
+func (s *CacheShard) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.indexHash)
+}
+
+func (s *CacheShard) Capacity() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.Capacity()
+}
+
+func (s *CacheShard) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Release()
+	s.indexHash = make(map[uint64]int)
 }
@@ -2,13 +2,297 @@ package bigcache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
 
-func TestNewBigCache(t *testing.T) {
-	c := NewBigCache(context.Background())
-	c.Set([]byte("key"), []byte("value"))
-	time.Sleep(time.Second*60)
-	c.Get([]byte("key"))
+func newTestCache(t testing.TB, config Config) *BigCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewBigCacheWithConfig(ctx, config)
+	t.Cleanup(func() {
+		cancel()
+		c.Close()
+	})
+	return c
+}
+
+func TestBigCache(t *testing.T) {
+	type op struct {
+		kind  string
+		key   string
+		value string
+		// elapsed is the time passed since the previous ops for "cleanup".
+		elapsed time.Duration
+		// wantErr is the expected error for "get" and "delete".
+		wantErr error
+	}
+
+	f := func(name string, ops []op) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			c := newTestCache(t, Config{
+				LifeWindow:  time.Second,
+				CleanWindow: time.Hour,
+			})
+			now := time.Now()
+			for i, o := range ops {
+				switch o.kind {
+				case "set":
+					if err := c.Set([]byte(o.key), []byte(o.value)); err != nil {
+						t.Fatalf("op #%d: cannot set %q: %s", i, o.key, err)
+					}
+				case "get":
+					v, err := c.Get([]byte(o.key))
+					if !errors.Is(err, o.wantErr) {
+						t.Fatalf("op #%d: unexpected error for %q; got %v; want %v", i, o.key, err, o.wantErr)
+					}
+					if err == nil && string(v) != o.value {
+						t.Fatalf("op #%d: unexpected value for %q; got %q; want %q", i, o.key, v, o.value)
+					}
+				case "delete":
+					if err := c.Delete([]byte(o.key)); !errors.Is(err, o.wantErr) {
+						t.Fatalf("op #%d: unexpected error when deleting %q; got %v; want %v", i, o.key, err, o.wantErr)
+					}
+				case "cleanup":
+					now = now.Add(o.elapsed)
+					for j := 0; j < Shards/c.config.CleanShardsPerTick; j++ {
+						c.ClearUp(now)
+					}
+				default:
+					t.Fatalf("op #%d: unknown kind %q", i, o.kind)
+				}
+			}
+		})
+	}
+
+	f("get missing", []op{
+		{kind: "get", key: "key", wantErr: ErrEntryNotFound},
+	})
+	f("set get", []op{
+		{kind: "set", key: "key", value: "value"},
+		{kind: "get", key: "key", value: "value"},
+	})
+	f("empty key and value", []op{
+		{kind: "set", key: "", value: ""},
+		{kind: "get", key: "", value: ""},
+	})
+	f("overwrite", []op{
+		{kind: "set", key: "key", value: "value1"},
+		{kind: "set", key: "key", value: "value2"},
+		{kind: "get", key: "key", value: "value2"},
+	})
+	f("delete", []op{
+		{kind: "set", key: "key", value: "value"},
+		{kind: "delete", key: "key"},
+		{kind: "get", key: "key", wantErr: ErrEntryNotFound},
+		{kind: "delete", key: "key", wantErr: ErrEntryNotFound},
+	})
+	f("set after delete", []op{
+		{kind: "set", key: "key", value: "value1"},
+		{kind: "delete", key: "key"},
+		{kind: "set", key: "key", value: "value2"},
+		{kind: "get", key: "key", value: "value2"},
+	})
+	f("not expired", []op{
+		{kind: "set", key: "key", value: "value"},
+		{kind: "cleanup", elapsed: 0},
+		{kind: "get", key: "key", value: "value"},
+	})
+	f("expired", []op{
+		{kind: "set", key: "key", value: "value"},
+		{kind: "cleanup", elapsed: 3 * time.Second},
+		{kind: "get", key: "key", wantErr: ErrEntryNotFound},
+	})
+	f("delete missing keeps others", []op{
+		{kind: "set", key: "key", value: "value"},
+		{kind: "delete", key: "other", wantErr: ErrEntryNotFound},
+		{kind: "get", key: "key", value: "value"},
+	})
+}
+
+func TestCacheShardCollision(t *testing.T) {
+	config := DefaultConfig()
+	s := (*CacheShard)(nil).InitShard(&config)
+	defer s.Release()
+
+	const hash = 42
+	if err := s.Set([]byte("key1"), []byte("value1"), hash); err != nil {
+		t.Fatalf("cannot set key1: %s", err)
+	}
+	if _, err := s.Get([]byte("key2"), hash); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("unexpected error for colliding key; got %v; want %v", err, ErrEntryNotFound)
+	}
+	if err := s.Delete([]byte("key2"), hash); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("unexpected error when deleting colliding key; got %v; want %v", err, ErrEntryNotFound)
+	}
+	v, err := s.Get([]byte("key1"), hash)
+	if err != nil {
+		t.Fatalf("cannot get key1: %s", err)
+	}
+	if string(v) != "value1" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "value1")
+	}
+
+	// The colliding key overwrites the previous one.
+	if err := s.Set([]byte("key2"), []byte("value2"), hash); err != nil {
+		t.Fatalf("cannot set key2: %s", err)
+	}
+	if _, err := s.Get([]byte("key1"), hash); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("unexpected error for overwritten key; got %v; want %v", err, ErrEntryNotFound)
+	}
+}
+
+func TestBigCacheMaxShardSize(t *testing.T) {
+	var removed []string
+	var mu sync.Mutex
+	c := newTestCache(t, Config{
+		InitialShardSize: 1024,
+		MaxShardSize:     4096,
+		OnRemove: func(k, v []byte) {
+			mu.Lock()
+			removed = append(removed, string(k))
+			mu.Unlock()
+		},
+	})
+
+	const n = 1e5
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("key_%d", i)
+		if err := c.Set([]byte(k), []byte("value")); err != nil {
+			t.Fatalf("cannot set %q: %s", k, err)
+		}
+	}
+	if len(removed) == 0 {
+		t.Fatalf("expecting evicted entries")
+	}
+	for _, s := range c.shards {
+		if s.data.Capacity() > 4096 {
+			t.Fatalf("shard capacity exceeds the limit; got %d bytes", s.data.Capacity())
+		}
+	}
+
+	// The last entry must be available.
+	k := fmt.Sprintf("key_%d", int(n-1))
+	if _, err := c.Get([]byte(k)); err != nil {
+		t.Fatalf("cannot get %q: %s", k, err)
+	}
+	// Evicted entries mustn't be available.
+	for _, k := range removed {
+		if v, err := c.Get([]byte(k)); err == nil {
+			t.Fatalf("unexpected value for evicted %q: %q", k, v)
+		}
+	}
+
+	if err := c.Set([]byte("big"), make([]byte, 8192)); err == nil {
+		t.Fatalf("expecting error for the entry exceeding MaxShardSize")
+	}
+}
+
+func TestBigCacheClearUp(t *testing.T) {
+	c := newTestCache(t, Config{
+		LifeWindow:         time.Second,
+		CleanWindow:        time.Hour,
+		CleanShardsPerTick: Shards,
+	})
+	for i := 0; i < 1000; i++ {
+		c.Set([]byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+	}
+
+	c.ClearUp(time.Now())
+	if stats := c.CleanUpStats(); stats.Evicted != 0 {
+		t.Fatalf("unexpected evicted entries; got %d; want 0", stats.Evicted)
+	}
+
+	c.ClearUp(time.Now().Add(time.Second * 3))
+	stats := c.CleanUpStats()
+	if stats.Runs != 2 {
+		t.Fatalf("unexpected runs; got %d; want 2", stats.Runs)
+	}
+	if stats.ShardsCleaned != 2*Shards {
+		t.Fatalf("unexpected shards cleaned; got %d; want %d", stats.ShardsCleaned, 2*Shards)
+	}
+	if stats.Evicted != 1000 {
+		t.Fatalf("unexpected evicted entries; got %d; want 1000", stats.Evicted)
+	}
+	if stats.MaxDuration < stats.LastDuration || stats.TotalDuration < stats.MaxDuration {
+		t.Fatalf("inconsistent durations: %+v", stats)
+	}
+}
+
+func TestBigCacheConcurrent(t *testing.T) {
+	c := newTestCache(t, Config{
+		LifeWindow:          time.Second,
+		CleanWindow:         10 * time.Millisecond,
+		MaxEvictionsPerLock: 4,
+		InitialShardSize:    256,
+		MaxShardSize:        8192,
+	})
+
+	const workers = 64
+	const keys = 2048
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				k := []byte(fmt.Sprintf("key_%d", (i*workers+w)%keys))
+				switch i % 4 {
+				case 0, 1:
+					if err := c.Set(k, k); err != nil {
+						panic(fmt.Errorf("cannot set %q: %w", k, err))
+					}
+				case 2:
+					v, err := c.Get(k)
+					if err == nil && string(v) != string(k) {
+						panic(fmt.Errorf("unexpected value for %q: %q", k, v))
+					}
+				case 3:
+					_ = c.Delete(k)
+				}
+				if i%1000 == 0 {
+					c.ClearUp(time.Now().Add(time.Duration(i) * time.Millisecond))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestCacheShardConcurrent(t *testing.T) {
+	config := DefaultConfig()
+	config.InitialShardSize = 128
+	config.MaxShardSize = 4096
+	config.MaxEvictionsPerLock = 1
+	config.LifeWindow = time.Second
+	s := (*CacheShard)(nil).InitShard(&config)
+	defer s.Release()
+
+	const workers = 32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				hash := uint64(i % 64)
+				k := []byte(fmt.Sprintf("key_%d", hash))
+				v := []byte(fmt.Sprintf("value_%d_%d", hash, w))
+				switch i % 5 {
+				case 0, 1:
+					if err := s.Set(k, v, hash); err != nil {
+						panic(fmt.Errorf("cannot set %q: %w", k, err))
+					}
+				case 2, 3:
+					_, _ = s.Get(k, hash)
+				case 4:
+					s.CleanUp(time.Now().Add(2 * time.Second))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
 }
@@ -0,0 +1,149 @@
+package bigcache
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchKeys = 1 << 16
+
+func benchmarkKey(dst []byte, i uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst[:0], i%benchKeys)
+}
+
+func BenchmarkBigCacheSet(b *testing.B) {
+	c := NewBigCacheWithConfig(context.Background(), Config{
+		LifeWindow:  time.Hour,
+		CleanWindow: time.Hour,
+	})
+	defer c.Close()
+	v := make([]byte, 32)
+
+	var n uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var k []byte
+		for pb.Next() {
+			k = benchmarkKey(k, atomic.AddUint64(&n, 1))
+			if err := c.Set(k, v); err != nil {
+				panic(err)
+			}
+		}
+	})
+}
+
+func BenchmarkBigCacheGet(b *testing.B) {
+	c := NewBigCacheWithConfig(context.Background(), Config{
+		LifeWindow:  time.Hour,
+		CleanWindow: time.Hour,
+	})
+	defer c.Close()
+	v := make([]byte, 32)
+	var k []byte
+	for i := uint64(0); i < benchKeys; i++ {
+		k = benchmarkKey(k, i)
+		if err := c.Set(k, v); err != nil {
+			b.Fatalf("cannot set: %s", err)
+		}
+	}
+
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var k []byte
+		for pb.Next() {
+			k = benchmarkKey(k, atomic.AddUint64(&n, 1))
+			if _, err := c.Get(k); err != nil {
+				panic(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSyncMapSet(b *testing.B) {
+	var m sync.Map
+	v := make([]byte, 32)
+
+	var n uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var k []byte
+		for pb.Next() {
+			k = benchmarkKey(k, atomic.AddUint64(&n, 1))
+			m.Store(string(k), append([]byte{}, v...))
+		}
+	})
+}
+
+func BenchmarkSyncMapGet(b *testing.B) {
+	var m sync.Map
+	v := make([]byte, 32)
+	var k []byte
+	for i := uint64(0); i < benchKeys; i++ {
+		k = benchmarkKey(k, i)
+		m.Store(string(k), append([]byte{}, v...))
+	}
+
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var k []byte
+		for pb.Next() {
+			k = benchmarkKey(k, atomic.AddUint64(&n, 1))
+			if _, ok := m.Load(string(k)); !ok {
+				panic("missing key")
+			}
+		}
+	})
+}
+
+func BenchmarkMutexMapSet(b *testing.B) {
+	var mu sync.RWMutex
+	m := make(map[string][]byte, benchKeys)
+	v := make([]byte, 32)
+
+	var n uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var k []byte
+		for pb.Next() {
+			k = benchmarkKey(k, atomic.AddUint64(&n, 1))
+			mu.Lock()
+			m[string(k)] = append([]byte{}, v...)
+			mu.Unlock()
+		}
+	})
+}
+
+func BenchmarkMutexMapGet(b *testing.B) {
+	var mu sync.RWMutex
+	m := make(map[string][]byte, benchKeys)
+	v := make([]byte, 32)
+	var k []byte
+	for i := uint64(0); i < benchKeys; i++ {
+		k = benchmarkKey(k, i)
+		m[string(k)] = append([]byte{}, v...)
+	}
+
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var k []byte
+		for pb.Next() {
+			k = benchmarkKey(k, atomic.AddUint64(&n, 1))
+			mu.RLock()
+			_, ok := m[string(k)]
+			mu.RUnlock()
+			if !ok {
+				panic("missing key")
+			}
+		}
+	})
+}
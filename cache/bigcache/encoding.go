@@ -11,6 +11,9 @@ const (
 func warpEntry(k, v []byte, timestamp uint64, hashIndex uint64, buffer *[]byte) []byte {
 
 	blobLen := timestampLen + hashLen + keySizeLen + len(k) + len(v)
+	if blobLen > len(*buffer) {
+		*buffer = make([]byte, blobLen)
+	}
 	blob := *buffer
 
 	binary.LittleEndian.PutUint64(blob, timestamp)
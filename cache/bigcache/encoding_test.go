@@ -0,0 +1,39 @@
+package bigcache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzWarpEntry(f *testing.F) {
+	f.Add([]byte("key"), []byte("value"), uint64(123), uint64(456))
+	f.Add([]byte{}, []byte{}, uint64(0), uint64(0))
+	f.Add(bytes.Repeat([]byte("k"), 1000), bytes.Repeat([]byte("v"), ShardSize), uint64(1<<63), uint64(1<<64-1))
+	f.Fuzz(func(t *testing.T, k, v []byte, timestamp, hash uint64) {
+		if len(k) > 1<<16-1 {
+			t.Skip()
+		}
+		buf := make([]byte, 16)
+		entry := warpEntry(k, v, timestamp, hash, &buf)
+
+		k1, v1, timestamp1, hash1 := readEntry(entry)
+		if !bytes.Equal(k1, k) {
+			t.Fatalf("unexpected key; got %q; want %q", k1, k)
+		}
+		if !bytes.Equal(v1, v) {
+			t.Fatalf("unexpected value; got %q; want %q", v1, v)
+		}
+		if timestamp1 != timestamp {
+			t.Fatalf("unexpected timestamp; got %d; want %d", timestamp1, timestamp)
+		}
+		if hash1 != hash {
+			t.Fatalf("unexpected hash; got %d; want %d", hash1, hash)
+		}
+		if ts := readEntryTimestamp(entry); ts != timestamp {
+			t.Fatalf("unexpected readEntryTimestamp; got %d; want %d", ts, timestamp)
+		}
+		if h := readEntryHash(entry); h != hash {
+			t.Fatalf("unexpected readEntryHash; got %d; want %d", h, hash)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	xxhash "github.com/cespare/xxhash/v2"
+	"hash/maphash"
+)
+
+// Hasher calculates the hash of a key. It selects the shard for the key and identifies the key inside the shard.
+type Hasher interface {
+	Sum64(k []byte) uint64
+}
+
+// XXHasher is the default Hasher based on xxhash.
+type XXHasher struct{}
+
+func (XXHasher) Sum64(k []byte) uint64 {
+	return xxhash.Sum64(k)
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// FNVHasher is the Hasher based on 64-bit FNV-1a.
+type FNVHasher struct{}
+
+func (FNVHasher) Sum64(k []byte) uint64 {
+	h := uint64(fnvOffset64)
+	for _, b := range k {
+		h ^= uint64(b)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// seededHasher is xxhash over the seed followed by the key.
+//
+// The seed randomizes shard placement. It doesn't make the hashes unpredictable, since xxhash isn't a keyed hash.
+type seededHasher struct {
+	d xxhash.Digest
+}
+
+// NewSeededHasher returns xxhash-based Hasher, which randomizes shard placement with seed.
+func NewSeededHasher(seed uint64) Hasher {
+	var h seededHasher
+	h.d.Reset()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], seed)
+	h.d.Write(buf[:])
+	return &h
+}
+
+func (h *seededHasher) Sum64(k []byte) uint64 {
+	d := h.d
+	d.Write(k)
+	return d.Sum64()
+}
+
+// maphashHasher is the Hasher based on hash/maphash with a random seed.
+type maphashHasher struct {
+	seed maphash.Seed
+}
+
+// NewRandomSeededHasher returns the Hasher based on hash/maphash with a random seed.
+//
+// Unlike NewSeededHasher, the seed is mixed into the hash function instead of being prepended to the key,
+// so it should be preferred for keys coming from untrusted clients. maphash isn't a cryptographic hash.
+// The hashes differ between processes, so they mustn't be persisted.
+func NewRandomSeededHasher() Hasher {
+	return &maphashHasher{
+		seed: maphash.MakeSeed(),
+	}
+}
+
+func (h *maphashHasher) Sum64(k []byte) uint64 {
+	return maphash.Bytes(h.seed, k)
+}
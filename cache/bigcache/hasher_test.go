@@ -0,0 +1,92 @@
+package bigcache
+
+import (
+	"fmt"
+	xxhash "github.com/cespare/xxhash/v2"
+	"hash/fnv"
+	"testing"
+	"time"
+)
+
+func TestHashers(t *testing.T) {
+	keys := []string{"", "a", "key", "foobar", string(make([]byte, 100))}
+	for _, k := range keys {
+		if h, want := (XXHasher{}).Sum64([]byte(k)), xxhash.Sum64String(k); h != want {
+			t.Fatalf("unexpected XXHasher hash for %q; got %d; want %d", k, h, want)
+		}
+
+		fh := fnv.New64a()
+		fh.Write([]byte(k))
+		if h, want := (FNVHasher{}).Sum64([]byte(k)), fh.Sum64(); h != want {
+			t.Fatalf("unexpected FNVHasher hash for %q; got %d; want %d", k, h, want)
+		}
+
+		h1 := NewSeededHasher(1)
+		h2 := NewSeededHasher(2)
+		if h1.Sum64([]byte(k)) != h1.Sum64([]byte(k)) {
+			t.Fatalf("seeded hash for %q isn't stable", k)
+		}
+		if h1.Sum64([]byte(k)) == h2.Sum64([]byte(k)) {
+			t.Fatalf("seeded hashes for %q mustn't match for distinct seeds", k)
+		}
+		if h1.Sum64([]byte(k)) == xxhash.Sum64String(k) {
+			t.Fatalf("seeded hash for %q mustn't match the unseeded hash", k)
+		}
+
+		rh := NewRandomSeededHasher()
+		if rh.Sum64([]byte(k)) != rh.Sum64([]byte(k)) {
+			t.Fatalf("random seeded hash for %q isn't stable", k)
+		}
+	}
+}
+
+func TestBigCacheHasher(t *testing.T) {
+	hashers := map[string]Hasher{
+		"xxhash":        XXHasher{},
+		"fnv":           FNVHasher{},
+		"seeded":        NewSeededHasher(42),
+		"random seeded": NewRandomSeededHasher(),
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			c := newTestCache(t, Config{
+				CleanWindow: time.Hour,
+				Hasher:      h,
+			})
+			for i := 0; i < 1000; i++ {
+				k := fmt.Sprintf("key_%d", i)
+				if err := c.Set([]byte(k), []byte(k)); err != nil {
+					t.Fatalf("cannot set %q: %s", k, err)
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				k := fmt.Sprintf("key_%d", i)
+				v, err := c.Get([]byte(k))
+				if err != nil {
+					t.Fatalf("cannot get %q: %s", k, err)
+				}
+				if string(v) != k {
+					t.Fatalf("unexpected value for %q: %q", k, v)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHasher(b *testing.B) {
+	hashers := map[string]Hasher{
+		"xxhash": XXHasher{},
+		"fnv":    FNVHasher{},
+		"seeded": NewSeededHasher(42),
+	}
+	k := []byte("key_for_benchmark_1234567890")
+	for name, h := range hashers {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(k)))
+			for i := 0; i < b.N; i++ {
+				h.Sum64(k)
+			}
+		})
+	}
+}
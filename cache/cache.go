@@ -0,0 +1,32 @@
+package cache
+
+// Cache is the common interface of the cache implementations.
+type Cache interface {
+	// Get appends the value for k to dst and returns the result.
+	// dst is returned unchanged if k is missing.
+	Get(dst, k []byte) []byte
+	// Set stores (k, v) in the cache. The entry may be dropped if it doesn't fit the cache.
+	Set(k, v []byte)
+	// Del deletes k from the cache.
+	Del(k []byte)
+	// Has returns true if k exists in the cache.
+	Has(k []byte) bool
+	// Reset removes all the entries from the cache.
+	Reset()
+	// Stats returns the cache statistics.
+	Stats() Stats
+}
+
+// Stats holds the statistics shared by the cache implementations.
+type Stats struct {
+	// GetCalls is the number of Get and Has calls.
+	GetCalls uint64
+	// SetCalls is the number of Set calls.
+	SetCalls uint64
+	// Misses is the number of Get and Has calls for missing keys.
+	Misses uint64
+	// EntriesCount is the number of entries in the cache.
+	EntriesCount uint64
+	// BytesSize is the memory occupied by the cache.
+	BytesSize uint64
+}
@@ -0,0 +1,26 @@
+package cache_test
+
+import (
+	"context"
+	"lxi/cache"
+	"lxi/cache/bigcache"
+	"lxi/cache/cachetest"
+	"testing"
+	"time"
+)
+
+func TestBigCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) cache.Cache {
+		c := bigcache.NewBigCacheWithConfig(context.Background(), bigcache.Config{
+			CleanWindow: time.Hour,
+		})
+		t.Cleanup(c.Close)
+		return cache.WrapBigCache(c)
+	})
+}
+
+func TestFastCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) cache.Cache {
+		return cache.NewFastCache(32 * 1024 * 1024)
+	})
+}
@@ -0,0 +1,196 @@
+// Package cachetest contains the conformance tests for cache.Cache implementations.
+package cachetest
+
+import (
+	"fmt"
+	"lxi/cache"
+	"sync"
+	"testing"
+)
+
+// EntriesCount is the number of small entries the tested cache must hold without evictions.
+const EntriesCount = 10000
+
+// TestCache runs the conformance tests against the caches returned by newCache.
+//
+// newCache must return an empty cache, which holds at least EntriesCount small entries.
+func TestCache(t *testing.T, newCache func(t *testing.T) cache.Cache) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, c cache.Cache)
+	}{
+		{"GetSet", testGetSet},
+		{"Overwrite", testOverwrite},
+		{"EmptyValue", testEmptyValue},
+		{"GetAppendsToDst", testGetAppendsToDst},
+		{"Del", testDel},
+		{"Reset", testReset},
+		{"ManyEntries", testManyEntries},
+		{"Stats", testStats},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f(t, newCache(t))
+		})
+	}
+}
+
+func testGetSet(t *testing.T, c cache.Cache) {
+	if v := c.Get(nil, []byte("key")); len(v) != 0 {
+		t.Fatalf("unexpected value for missing key: %q", v)
+	}
+	if c.Has([]byte("key")) {
+		t.Fatalf("unexpected missing key found")
+	}
+	c.Set([]byte("key"), []byte("value"))
+	if v := c.Get(nil, []byte("key")); string(v) != "value" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "value")
+	}
+	if !c.Has([]byte("key")) {
+		t.Fatalf("cannot find key")
+	}
+}
+
+func testOverwrite(t *testing.T, c cache.Cache) {
+	c.Set([]byte("key"), []byte("value1"))
+	c.Set([]byte("key"), []byte("value2"))
+	if v := c.Get(nil, []byte("key")); string(v) != "value2" {
+		t.Fatalf("unexpected value after overwrite; got %q; want %q", v, "value2")
+	}
+}
+
+func testEmptyValue(t *testing.T, c cache.Cache) {
+	c.Set([]byte("key"), nil)
+	if !c.Has([]byte("key")) {
+		t.Fatalf("cannot find the key with empty value")
+	}
+	if v := c.Get(nil, []byte("key")); len(v) != 0 {
+		t.Fatalf("unexpected non-empty value: %q", v)
+	}
+}
+
+func testGetAppendsToDst(t *testing.T, c cache.Cache) {
+	c.Set([]byte("key"), []byte("value"))
+	if v := c.Get([]byte("dst_"), []byte("key")); string(v) != "dst_value" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "dst_value")
+	}
+	if v := c.Get([]byte("dst_"), []byte("missing")); string(v) != "dst_" {
+		t.Fatalf("unexpected dst for missing key; got %q; want %q", v, "dst_")
+	}
+}
+
+func testDel(t *testing.T, c cache.Cache) {
+	c.Set([]byte("key"), []byte("value"))
+	c.Set([]byte("other"), []byte("value"))
+	c.Del([]byte("key"))
+	if c.Has([]byte("key")) {
+		t.Fatalf("unexpected key found after deletion")
+	}
+	if !c.Has([]byte("other")) {
+		t.Fatalf("cannot find the key, which wasn't deleted")
+	}
+	// Deleting a missing key is a no-op.
+	c.Del([]byte("missing"))
+
+	c.Set([]byte("key"), []byte("value2"))
+	if v := c.Get(nil, []byte("key")); string(v) != "value2" {
+		t.Fatalf("unexpected value after re-adding the key; got %q; want %q", v, "value2")
+	}
+}
+
+func testReset(t *testing.T, c cache.Cache) {
+	for i := 0; i < 100; i++ {
+		c.Set([]byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+	}
+	c.Reset()
+	for i := 0; i < 100; i++ {
+		if k := []byte(fmt.Sprintf("key_%d", i)); c.Has(k) {
+			t.Fatalf("unexpected key %q found after Reset", k)
+		}
+	}
+	if n := c.Stats().EntriesCount; n != 0 {
+		t.Fatalf("unexpected EntriesCount after Reset; got %d; want 0", n)
+	}
+	c.Set([]byte("key"), []byte("value"))
+	if v := c.Get(nil, []byte("key")); string(v) != "value" {
+		t.Fatalf("unexpected value after Reset; got %q; want %q", v, "value")
+	}
+}
+
+func testManyEntries(t *testing.T, c cache.Cache) {
+	for i := 0; i < EntriesCount; i++ {
+		c.Set([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	var buf []byte
+	for i := 0; i < EntriesCount; i++ {
+		k := fmt.Sprintf("key_%d", i)
+		buf = c.Get(buf[:0], []byte(k))
+		if want := fmt.Sprintf("value_%d", i); string(buf) != want {
+			t.Fatalf("unexpected value for %q; got %q; want %q", k, buf, want)
+		}
+	}
+}
+
+func testStats(t *testing.T, c cache.Cache) {
+	for i := 0; i < 100; i++ {
+		c.Set([]byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+	}
+	for i := 0; i < 150; i++ {
+		c.Get(nil, []byte(fmt.Sprintf("key_%d", i)))
+	}
+	c.Has([]byte("key_0"))
+	c.Has([]byte("missing"))
+
+	s := c.Stats()
+	if s.SetCalls != 100 {
+		t.Fatalf("unexpected SetCalls; got %d; want 100", s.SetCalls)
+	}
+	if s.GetCalls != 152 {
+		t.Fatalf("unexpected GetCalls; got %d; want 152", s.GetCalls)
+	}
+	if s.Misses != 51 {
+		t.Fatalf("unexpected Misses; got %d; want 51", s.Misses)
+	}
+	if s.EntriesCount != 100 {
+		t.Fatalf("unexpected EntriesCount; got %d; want 100", s.EntriesCount)
+	}
+	if s.BytesSize == 0 {
+		t.Fatalf("BytesSize must be positive")
+	}
+}
+
+func testConcurrent(t *testing.T, c cache.Cache) {
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var buf []byte
+			for i := 0; i < EntriesCount/workers; i++ {
+				k := []byte(fmt.Sprintf("key_%d_%d", w, i))
+				v := []byte(fmt.Sprintf("value_%d_%d", w, i))
+				c.Set(k, v)
+				buf = c.Get(buf[:0], k)
+				if string(buf) != string(v) {
+					errs <- fmt.Errorf("unexpected value for %q; got %q; want %q", k, buf, v)
+					return
+				}
+				if i%3 == 0 {
+					c.Del(k)
+					if c.Has(k) {
+						errs <- fmt.Errorf("unexpected key %q found after deletion", k)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
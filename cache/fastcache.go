@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"lxi/cache/fastcache"
+)
+
+type fastCache struct {
+	c *fastcache.Cache
+}
+
+// NewFastCache returns Cache backed by fastcache.Cache with maxBytes capacity.
+func NewFastCache(maxBytes int) Cache {
+	return WrapFastCache(fastcache.New(maxBytes))
+}
+
+// WrapFastCache returns Cache backed by c.
+func WrapFastCache(c *fastcache.Cache) Cache {
+	return &fastCache{c: c}
+}
+
+func (fc *fastCache) Get(dst, k []byte) []byte {
+	return fc.c.Get(dst, k)
+}
+
+func (fc *fastCache) Set(k, v []byte) {
+	fc.c.Set(k, v)
+}
+
+func (fc *fastCache) Del(k []byte) {
+	fc.c.Del(k)
+}
+
+func (fc *fastCache) Has(k []byte) bool {
+	return fc.c.Has(k)
+}
+
+func (fc *fastCache) Reset() {
+	fc.c.Reset()
+}
+
+func (fc *fastCache) Stats() Stats {
+	var s fastcache.Stats
+	fc.c.UpdateStats(&s)
+	return Stats{
+		GetCalls:     s.GetCalls,
+		SetCalls:     s.SetCalls,
+		Misses:       s.Misses,
+		EntriesCount: s.EntriesCount,
+		BytesSize:    s.BytesSize,
+	}
+}
@@ -0,0 +1,113 @@
+package fastcache
+
+import (
+	"encoding/binary"
+	xxhash "github.com/cespare/xxhash/v2"
+	"sync"
+)
+
+const (
+	// subkeyLen is the size of the sub-entry key: the value hash and the sub-entry index.
+	subkeyLen = 16
+
+	// metavalueLen is the size of the metadata entry value: the value hash and the value length.
+	metavalueLen = 16
+
+	maxSubvalueLen = ChunkSize - subkeyLen - kvLenSize - 1
+	maxKeyLen      = ChunkSize - metavalueLen - kvLenSize - 1
+)
+
+// SetBig stores (k, v) in the cache, where v may exceed ChunkSize.
+//
+// v is split into sub-entries keyed by the hash of v and the sub-entry index.
+// The entry for k holds the hash and the length of v.
+// The stored value must be read with GetBig.
+func (c *Cache) SetBig(k, v []byte) {
+	if len(k) > maxKeyLen {
+		return
+	}
+	valueLen := len(v)
+	valueHash := xxhash.Sum64(v)
+
+	subkey := getSubkeyBuf()
+	defer putSubkeyBuf(subkey)
+
+	var i uint64
+	for len(v) > 0 {
+		*subkey = marshalSubkey((*subkey)[:0], valueHash, i)
+		i++
+		n := maxSubvalueLen
+		if len(v) < n {
+			n = len(v)
+		}
+		c.Set(*subkey, v[:n])
+		v = v[n:]
+	}
+
+	// The metadata entry is written last, so GetBig never sees it before all the sub-entries.
+	*subkey = marshalSubkey((*subkey)[:0], valueHash, uint64(valueLen))
+	c.Set(k, *subkey)
+}
+
+// GetBig appends the value stored with SetBig for k to dst and returns the result.
+//
+// dst is returned unchanged if k is missing or any sub-entry has been overwritten,
+// so partial values are never returned.
+func (c *Cache) GetBig(dst, k []byte) []byte {
+	subkey := getSubkeyBuf()
+	defer putSubkeyBuf(subkey)
+
+	meta, ok := c.get((*subkey)[:0], k, true)
+	*subkey = meta
+	if !ok || len(meta) != metavalueLen {
+		return dst
+	}
+	valueHash := binary.BigEndian.Uint64(meta)
+	valueLen := binary.BigEndian.Uint64(meta[8:])
+
+	dstLen := len(dst)
+	if n := dstLen + int(valueLen) - cap(dst); n > 0 {
+		dst = append(dst[:cap(dst)], make([]byte, n)...)
+	}
+	dst = dst[:dstLen]
+
+	var i uint64
+	for uint64(len(dst)-dstLen) < valueLen {
+		*subkey = marshalSubkey((*subkey)[:0], valueHash, i)
+		i++
+		dstNew, ok := c.get(dst, *subkey, true)
+		if !ok || len(dstNew) == len(dst) {
+			// The sub-entry has been overwritten.
+			return dst[:dstLen]
+		}
+		dst = dstNew
+	}
+
+	v := dst[dstLen:]
+	if uint64(len(v)) != valueLen || xxhash.Sum64(v) != valueHash {
+		// The sub-entries belong to distinct values.
+		return dst[:dstLen]
+	}
+	return dst
+}
+
+func marshalSubkey(dst []byte, valueHash, n uint64) []byte {
+	dst = binary.BigEndian.AppendUint64(dst, valueHash)
+	return binary.BigEndian.AppendUint64(dst, n)
+}
+
+var subkeyPool sync.Pool
+
+func getSubkeyBuf() *[]byte {
+	v := subkeyPool.Get()
+	if v == nil {
+		b := make([]byte, 0, subkeyLen)
+		return &b
+	}
+	return v.(*[]byte)
+}
+
+func putSubkeyBuf(b *[]byte) {
+	*b = (*b)[:0]
+	subkeyPool.Put(b)
+}
@@ -0,0 +1,88 @@
+package fastcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestSetGetBig(t *testing.T) {
+	c := New(256 * 1024 * 1024)
+	const valuesCount = 10
+	for _, valueSize := range []int{1, 100, 1 << 16, 1<<16 - 1, 1 << 17, 1 << 20, 5*(1<<20) + 123} {
+		t.Run(fmt.Sprintf("size_%d", valueSize), func(t *testing.T) {
+			for seed := 0; seed < valuesCount; seed++ {
+				k := []byte(fmt.Sprintf("key %d", seed))
+				v := createValue(valueSize, seed)
+				c.SetBig(k, v)
+				vv := c.GetBig(nil, k)
+				if !bytes.Equal(vv, v) {
+					t.Fatalf("seed=%d; unexpected value obtained; got len=%d; want len=%d", seed, len(vv), len(v))
+				}
+			}
+
+			// The value must be appended to dst.
+			k := []byte("key 0")
+			v := createValue(valueSize, 0)
+			vv := c.GetBig([]byte("prefix"), k)
+			if !bytes.Equal(vv, append([]byte("prefix"), v...)) {
+				t.Fatalf("unexpected value appended to dst; got len=%d; want len=%d", len(vv), len("prefix")+len(v))
+			}
+		})
+	}
+
+	if vv := c.GetBig(nil, []byte("missing key")); len(vv) != 0 {
+		t.Fatalf("unexpected non-empty value for missing key: len=%d", len(vv))
+	}
+}
+
+func TestGetBigOverwrittenSubentry(t *testing.T) {
+	c := New(256 * 1024 * 1024)
+	k := []byte("key")
+	v := createValue(1<<20, 1)
+	c.SetBig(k, v)
+
+	// Remove a sub-entry in the middle of the value.
+	meta := c.Get(nil, k)
+	c.Del(marshalSubkey(nil, binary.BigEndian.Uint64(meta), 3))
+	if vv := c.GetBig([]byte("dst"), k); string(vv) != "dst" {
+		t.Fatalf("unexpected value with missing sub-entry; got len=%d; want dst only", len(vv))
+	}
+
+	// Overwrite the whole ring, so all the sub-entries are gone.
+	c = New(1024 * 1024)
+	c.SetBig(k, v)
+	for i := 0; i < 100; i++ {
+		c.SetBig([]byte(fmt.Sprintf("other %d", i)), createValue(1<<18, i))
+	}
+	vv := c.GetBig(nil, k)
+	if len(vv) != 0 && !bytes.Equal(vv, v) {
+		t.Fatalf("partial value returned; got len=%d; want len=%d", len(vv), len(v))
+	}
+}
+
+func TestGetBigMismatchedHash(t *testing.T) {
+	c := New(256 * 1024 * 1024)
+	k := []byte("key")
+	v := createValue(1<<18, 1)
+	c.SetBig(k, v)
+
+	// Corrupt the first sub-entry, so the value hash doesn't match.
+	meta := c.Get(nil, k)
+	subkey := marshalSubkey(nil, binary.BigEndian.Uint64(meta), 0)
+	corrupted := c.Get(nil, subkey)
+	corrupted[0]++
+	c.Set(subkey, corrupted)
+	if vv := c.GetBig(nil, k); len(vv) != 0 {
+		t.Fatalf("unexpected value with corrupted sub-entry: len=%d", len(vv))
+	}
+}
+
+func createValue(size, seed int) []byte {
+	var buf []byte
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(i+seed))
+	}
+	return buf
+}
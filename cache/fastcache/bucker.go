@@ -1 +1,234 @@
 package fastcache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// kvLenSize is the size of the key and value lengths stored in front of every entry.
+const kvLenSize = 4
+
+type bucket struct {
+	mu sync.RWMutex
+
+	// chunks is a ring of ChunkSize chunks holding the entries.
+	chunks [][]byte
+
+	// m maps the key hash to the entry offset in the ring and the generation of the ring.
+	m map[uint64]uint64
+
+	// idx is the offset in the ring for the next entry.
+	idx uint64
+
+	// gen is the generation of the ring. It is incremented when idx wraps to the beginning of the ring.
+	gen uint64
+
+	getCalls    uint64
+	setCalls    uint64
+	misses      uint64
+	collisions  uint64
+	corruptions uint64
+}
+
+func (b *bucket) Init(maxBytes uint64) {
+	if maxBytes == 0 {
+		panic(fmt.Errorf("maxBytes cannot be zero"))
+	}
+	if maxBytes >= maxBucketSize {
+		panic(fmt.Errorf("too big maxBytes=%d; should be smaller than %d", maxBytes, maxBucketSize))
+	}
+	maxChunks := (maxBytes + ChunkSize - 1) / ChunkSize
+	b.chunks = make([][]byte, maxChunks)
+	b.Reset()
+}
+
+func (b *bucket) Reset() {
+	b.mu.Lock()
+	for i := range b.chunks {
+		b.chunks[i] = nil
+	}
+	b.m = make(map[uint64]uint64)
+	b.idx = 0
+	b.gen = 1
+	atomic.StoreUint64(&b.getCalls, 0)
+	atomic.StoreUint64(&b.setCalls, 0)
+	atomic.StoreUint64(&b.misses, 0)
+	atomic.StoreUint64(&b.collisions, 0)
+	atomic.StoreUint64(&b.corruptions, 0)
+	b.mu.Unlock()
+}
+
+func (b *bucket) UpdateStats(s *Stats) {
+	s.GetCalls += atomic.LoadUint64(&b.getCalls)
+	s.SetCalls += atomic.LoadUint64(&b.setCalls)
+	s.Misses += atomic.LoadUint64(&b.misses)
+	s.Collisions += atomic.LoadUint64(&b.collisions)
+	s.Corruptions += atomic.LoadUint64(&b.corruptions)
+
+	b.mu.RLock()
+	s.EntriesCount += uint64(len(b.m))
+	for _, chunk := range b.chunks {
+		s.BytesSize += uint64(cap(chunk))
+	}
+	s.MaxBytesSize += uint64(len(b.chunks)) * ChunkSize
+	b.mu.RUnlock()
+}
+
+func (b *bucket) Set(k, v []byte, h uint64) {
+	atomic.AddUint64(&b.setCalls, 1)
+	if len(k) >= 1<<16 || len(v) >= 1<<16 {
+		// The lengths cannot be encoded into 2 bytes.
+		return
+	}
+	var kvLenBuf [kvLenSize]byte
+	kvLenBuf[0] = byte(uint16(len(k)) >> 8)
+	kvLenBuf[1] = byte(len(k))
+	kvLenBuf[2] = byte(uint16(len(v)) >> 8)
+	kvLenBuf[3] = byte(len(v))
+	kvLen := uint64(len(kvLenBuf) + len(k) + len(v))
+	if kvLen >= ChunkSize {
+		// The entry doesn't fit a chunk.
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	chunks := b.chunks
+	needClean := false
+	idx := b.idx
+	idxNew := idx + kvLen
+	chunkIdx := idx / ChunkSize
+	chunkIdxNew := idxNew / ChunkSize
+	if chunkIdxNew > chunkIdx {
+		if chunkIdxNew >= uint64(len(chunks)) {
+			// Wrap to the beginning of the ring and start overwriting the oldest chunks.
+			idx = 0
+			idxNew = kvLen
+			chunkIdx = 0
+			b.gen++
+			if b.gen&maxGen == 0 {
+				b.gen++
+			}
+			needClean = true
+		} else {
+			idx = chunkIdxNew * ChunkSize
+			idxNew = idx + kvLen
+			chunkIdx = chunkIdxNew
+		}
+		chunks[chunkIdx] = chunks[chunkIdx][:0]
+	}
+	chunk := chunks[chunkIdx]
+	if chunk == nil {
+		chunk = make([]byte, 0, ChunkSize)
+	}
+	chunk = append(chunk, kvLenBuf[:]...)
+	chunk = append(chunk, k...)
+	chunk = append(chunk, v...)
+	chunks[chunkIdx] = chunk
+	b.m[h] = idx | (b.gen << bucketSizeBits)
+	b.idx = idxNew
+	if needClean {
+		b.cleanLocked()
+	}
+}
+
+// isLiveLocked returns true if the entry at idx with the given generation hasn't been overwritten yet.
+func (b *bucket) isLiveLocked(gen, idx uint64) bool {
+	bGen := b.gen & maxGen
+	return gen == bGen && idx < b.idx ||
+		gen+1 == bGen && idx >= b.idx ||
+		gen == maxGen && bGen == 1 && idx >= b.idx
+}
+
+// cleanLocked drops the overwritten entries from b.m.
+func (b *bucket) cleanLocked() {
+	bm := b.m
+	liveItems := 0
+	for _, v := range bm {
+		if b.isLiveLocked(v>>bucketSizeBits, v&(maxBucketSize-1)) {
+			liveItems++
+		}
+	}
+	if liveItems == len(bm) {
+		return
+	}
+	// Re-create the map instead of deleting items from it, since the map never shrinks.
+	bmNew := make(map[uint64]uint64, liveItems)
+	for h, v := range bm {
+		if b.isLiveLocked(v>>bucketSizeBits, v&(maxBucketSize-1)) {
+			bmNew[h] = v
+		}
+	}
+	b.m = bmNew
+}
+
+// Get appends the value for k to dst if returnDst is set.
+// It returns false if k is missing.
+func (b *bucket) Get(dst, k []byte, h uint64, returnDst bool) ([]byte, bool) {
+	atomic.AddUint64(&b.getCalls, 1)
+	b.mu.RLock()
+	dst, found := b.getLocked(dst, k, h, returnDst)
+	b.mu.RUnlock()
+	if !found {
+		atomic.AddUint64(&b.misses, 1)
+	}
+	return dst, found
+}
+
+func (b *bucket) getLocked(dst, k []byte, h uint64, returnDst bool) ([]byte, bool) {
+	v, ok := b.m[h]
+	if !ok {
+		return dst, false
+	}
+	gen := v >> bucketSizeBits
+	idx := v & (maxBucketSize - 1)
+	if !b.isLiveLocked(gen, idx) {
+		return dst, false
+	}
+
+	chunkIdx := idx / ChunkSize
+	if chunkIdx >= uint64(len(b.chunks)) {
+		// The offset may be broken only in the data loaded from file.
+		atomic.AddUint64(&b.corruptions, 1)
+		return dst, false
+	}
+	chunk := b.chunks[chunkIdx]
+	idx %= ChunkSize
+	if idx+kvLenSize >= ChunkSize {
+		atomic.AddUint64(&b.corruptions, 1)
+		return dst, false
+	}
+	if idx+kvLenSize > uint64(len(chunk)) {
+		// The entry is in the current chunk, which has been truncated on wrap.
+		return dst, false
+	}
+	kvLenBuf := chunk[idx : idx+kvLenSize]
+	keyLen := uint64(kvLenBuf[0])<<8 | uint64(kvLenBuf[1])
+	valLen := uint64(kvLenBuf[2])<<8 | uint64(kvLenBuf[3])
+	idx += kvLenSize
+	if idx+keyLen+valLen >= ChunkSize {
+		atomic.AddUint64(&b.corruptions, 1)
+		return dst, false
+	}
+	if idx+keyLen+valLen > uint64(len(chunk)) {
+		return dst, false
+	}
+	if string(k) != string(chunk[idx:idx+keyLen]) {
+		// Hash collision with another key.
+		atomic.AddUint64(&b.collisions, 1)
+		return dst, false
+	}
+	idx += keyLen
+	if returnDst {
+		dst = append(dst, chunk[idx:idx+valLen]...)
+	}
+	return dst, true
+}
+
+func (b *bucket) Del(h uint64) {
+	b.mu.Lock()
+	delete(b.m, h)
+	b.mu.Unlock()
+}
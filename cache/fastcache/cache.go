@@ -1 +1,130 @@
 package fastcache
+
+import (
+	"fmt"
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+const (
+	BucketsCount = 512
+	ChunkSize    = 64 * 1024
+
+	// bucketSizeBits is the number of bits in the map values holding the offset of the entry in the bucket.
+	// The remaining bits hold the generation of the bucket.
+	bucketSizeBits = 40
+	genSizeBits    = 64 - bucketSizeBits
+	maxGen         = 1<<genSizeBits - 1
+	maxBucketSize  = 1 << bucketSizeBits
+)
+
+// Cache is a fixed-size in-memory cache.
+//
+// The memory is split into BucketsCount buckets. Every bucket is a ring of ChunkSize chunks,
+// so new entries overwrite the oldest ones when the cache is full. There is no background cleanup.
+//
+// Entries with len(k)+len(v) close to ChunkSize and bigger aren't stored.
+type Cache struct {
+	buckets [BucketsCount]bucket
+
+	maxBytes uint64
+}
+
+// New returns a cache with maxBytes capacity.
+func New(maxBytes int) *Cache {
+	if maxBytes <= 0 {
+		panic(fmt.Errorf("maxBytes must be greater than 0; got %d", maxBytes))
+	}
+	var c Cache
+	c.maxBytes = uint64(maxBytes)
+	maxBucketBytes := getMaxBucketBytes(maxBytes)
+	for i := range c.buckets[:] {
+		c.buckets[i].Init(maxBucketBytes)
+	}
+	return &c
+}
+
+func getMaxBucketBytes(maxBytes int) uint64 {
+	return uint64((maxBytes + BucketsCount - 1) / BucketsCount)
+}
+
+// Set stores (k, v) in the cache. k and v may be modified after returning from Set.
+func (c *Cache) Set(k, v []byte) {
+	h := xxhash.Sum64(k)
+	idx := h % BucketsCount
+	c.buckets[idx].Set(k, v, h)
+}
+
+// Get appends the value for k to dst and returns the result.
+//
+// dst is returned unchanged if k is missing in the cache. Use HasGet in order to distinguish
+// an empty value from a missing one.
+// Get doesn't allocate memory if dst has enough capacity for the value.
+func (c *Cache) Get(dst, k []byte) []byte {
+	dst, _ = c.get(dst, k, true)
+	return dst
+}
+
+// HasGet works like Get, but also returns whether k exists in the cache.
+func (c *Cache) HasGet(dst, k []byte) ([]byte, bool) {
+	return c.get(dst, k, true)
+}
+
+// Has returns true if k exists in the cache.
+func (c *Cache) Has(k []byte) bool {
+	_, ok := c.get(nil, k, false)
+	return ok
+}
+
+func (c *Cache) get(dst, k []byte, returnDst bool) ([]byte, bool) {
+	h := xxhash.Sum64(k)
+	idx := h % BucketsCount
+	return c.buckets[idx].Get(dst, k, h, returnDst)
+}
+
+// Del deletes k from the cache.
+func (c *Cache) Del(k []byte) {
+	h := xxhash.Sum64(k)
+	idx := h % BucketsCount
+	c.buckets[idx].Del(h)
+}
+
+// Stats holds the cache statistics.
+type Stats struct {
+	// GetCalls is the number of Get, Has and GetBig sub-entry calls.
+	GetCalls uint64
+	// SetCalls is the number of Set and SetBig sub-entry calls.
+	SetCalls uint64
+	// Misses is the number of cache misses.
+	Misses uint64
+	// Collisions is the number of hash collisions.
+	Collisions uint64
+	// Corruptions is the number of corrupted entries detected, e.g. after loading a broken file.
+	Corruptions uint64
+	// EntriesCount is the number of entries in the cache.
+	EntriesCount uint64
+	// BytesSize is the memory allocated by the cache.
+	BytesSize uint64
+	// MaxBytesSize is the maximum memory the cache may allocate.
+	MaxBytesSize uint64
+}
+
+// Reset resets s, so it may be re-used in UpdateStats.
+func (s *Stats) Reset() {
+	*s = Stats{}
+}
+
+// UpdateStats adds the cache stats to s.
+//
+// Call s.Reset before calling UpdateStats if s is re-used.
+func (c *Cache) UpdateStats(s *Stats) {
+	for i := range c.buckets[:] {
+		c.buckets[i].UpdateStats(s)
+	}
+}
+
+// Reset removes all the entries from the cache and resets the stats.
+func (c *Cache) Reset() {
+	for i := range c.buckets[:] {
+		c.buckets[i].Reset()
+	}
+}
@@ -1 +1,318 @@
 package fastcache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCacheSmall(t *testing.T) {
+	c := New(1)
+
+	if v := c.Get(nil, []byte("aaa")); len(v) != 0 {
+		t.Fatalf("unexpected non-empty value obtained from small cache: %q", v)
+	}
+	c.Set([]byte("key"), []byte("value"))
+	if v := c.Get(nil, []byte("key")); string(v) != "value" {
+		t.Fatalf("unexpected value obtained; got %q; want %q", v, "value")
+	}
+	if !c.Has([]byte("key")) {
+		t.Fatalf("cannot find key")
+	}
+	if c.Has([]byte("aaa")) {
+		t.Fatalf("unexpected key found")
+	}
+
+	c.Set([]byte("key"), []byte("value2"))
+	if v := c.Get(nil, []byte("key")); string(v) != "value2" {
+		t.Fatalf("unexpected value after overwrite; got %q; want %q", v, "value2")
+	}
+
+	c.Del([]byte("key"))
+	if c.Has([]byte("key")) {
+		t.Fatalf("unexpected key found after deletion")
+	}
+
+	c.Set([]byte("key"), []byte("value"))
+	c.Reset()
+	if c.Has([]byte("key")) {
+		t.Fatalf("unexpected key found after reset")
+	}
+}
+
+func TestCacheTooBigEntry(t *testing.T) {
+	c := New(1024)
+
+	c.Set([]byte("key"), make([]byte, ChunkSize))
+	if c.Has([]byte("key")) {
+		t.Fatalf("unexpected entry exceeding ChunkSize")
+	}
+	c.Set(make([]byte, 1<<16), []byte("value"))
+	if c.Has(make([]byte, 1<<16)) {
+		t.Fatalf("unexpected entry with too long key")
+	}
+}
+
+func TestCacheWrap(t *testing.T) {
+	c := New(BucketsCount * ChunkSize)
+
+	const n = 500000
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key_%d", i))
+		v := []byte(fmt.Sprintf("value_%0100d", i))
+		c.Set(k, v)
+		if got := c.Get(nil, k); string(got) != string(v) {
+			t.Fatalf("unexpected value for %q; got %q; want %q", k, got, v)
+		}
+	}
+
+	// The oldest entries must be overwritten by the newest ones.
+	missing := 0
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key_%d", i))
+		v := c.Get(nil, k)
+		if v == nil {
+			missing++
+			continue
+		}
+		if want := fmt.Sprintf("value_%0100d", i); string(v) != want {
+			t.Fatalf("unexpected value for %q; got %q; want %q", k, v, want)
+		}
+	}
+	if missing == 0 {
+		t.Fatalf("expecting overwritten entries")
+	}
+	for i := n - 1000; i < n; i++ {
+		k := []byte(fmt.Sprintf("key_%d", i))
+		if !c.Has(k) {
+			t.Fatalf("cannot find recently added %q", k)
+		}
+	}
+
+	// Overwritten entries must be dropped from the bucket maps on wrap.
+	for i := range c.buckets[:] {
+		b := &c.buckets[i]
+		if len(b.m) > 2*ChunkSize/(kvLenSize+len("key_1")+len("value_")+100) {
+			t.Fatalf("too many entries in bucket #%d: %d", i, len(b.m))
+		}
+	}
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	c := New(1024 * 1024)
+
+	const workers = 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				k := []byte(fmt.Sprintf("key_%d_%d", w, i))
+				v := []byte(fmt.Sprintf("value_%d_%d", w, i))
+				c.Set(k, v)
+				if got := c.Get(nil, k); got != nil && string(got) != string(v) {
+					panic(fmt.Errorf("unexpected value for %q; got %q; want %q", k, got, v))
+				}
+				if i%10 == 0 {
+					c.Del(k)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestCacheStats(t *testing.T) {
+	c := New(BucketsCount * ChunkSize)
+
+	const itemsCount = 1000
+	for i := 0; i < itemsCount; i++ {
+		c.Set([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	for i := 0; i < 2*itemsCount; i++ {
+		c.Get(nil, []byte(fmt.Sprintf("key_%d", i)))
+	}
+
+	var s Stats
+	c.UpdateStats(&s)
+	if s.SetCalls != itemsCount {
+		t.Fatalf("unexpected SetCalls; got %d; want %d", s.SetCalls, itemsCount)
+	}
+	if s.GetCalls != 2*itemsCount {
+		t.Fatalf("unexpected GetCalls; got %d; want %d", s.GetCalls, 2*itemsCount)
+	}
+	if s.Misses != itemsCount {
+		t.Fatalf("unexpected Misses; got %d; want %d", s.Misses, itemsCount)
+	}
+	if s.EntriesCount != itemsCount {
+		t.Fatalf("unexpected EntriesCount; got %d; want %d", s.EntriesCount, itemsCount)
+	}
+	if s.BytesSize == 0 || s.BytesSize > s.MaxBytesSize {
+		t.Fatalf("unexpected BytesSize=%d for MaxBytesSize=%d", s.BytesSize, s.MaxBytesSize)
+	}
+	if s.MaxBytesSize != BucketsCount*ChunkSize {
+		t.Fatalf("unexpected MaxBytesSize; got %d; want %d", s.MaxBytesSize, BucketsCount*ChunkSize)
+	}
+
+	// Collision: distinct key with the same hash.
+	b := &c.buckets[0]
+	b.Set([]byte("key1"), []byte("value1"), 0)
+	b.Get(nil, []byte("key2"), 0, true)
+	// Corruption: the offset points outside the ring.
+	b.mu.Lock()
+	b.m[BucketsCount] = uint64(len(b.chunks))*ChunkSize | b.gen<<bucketSizeBits
+	b.idx = uint64(len(b.chunks))*ChunkSize + 1
+	b.mu.Unlock()
+	b.Get(nil, []byte("key3"), BucketsCount, true)
+
+	s.Reset()
+	c.UpdateStats(&s)
+	if s.Collisions != 1 {
+		t.Fatalf("unexpected Collisions; got %d; want 1", s.Collisions)
+	}
+	if s.Corruptions != 1 {
+		t.Fatalf("unexpected Corruptions; got %d; want 1", s.Corruptions)
+	}
+
+	c.Reset()
+	s.Reset()
+	c.UpdateStats(&s)
+	if s.GetCalls != 0 || s.SetCalls != 0 || s.EntriesCount != 0 || s.BytesSize != 0 {
+		t.Fatalf("unexpected stats after Reset: %+v", s)
+	}
+}
+
+func TestCacheHasGet(t *testing.T) {
+	c := New(1024)
+	c.Set([]byte("empty"), nil)
+	c.Set([]byte("key"), []byte("value"))
+
+	v, ok := c.HasGet(nil, []byte("empty"))
+	if !ok {
+		t.Fatalf("cannot find the key with empty value")
+	}
+	if len(v) != 0 {
+		t.Fatalf("unexpected non-empty value: %q", v)
+	}
+	if _, ok := c.HasGet(nil, []byte("missing")); ok {
+		t.Fatalf("unexpected missing key found")
+	}
+
+	v, ok = c.HasGet([]byte("dst_"), []byte("key"))
+	if !ok {
+		t.Fatalf("cannot find key")
+	}
+	if string(v) != "dst_value" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "dst_value")
+	}
+	if v := c.Get([]byte("dst_"), []byte("missing")); string(v) != "dst_" {
+		t.Fatalf("unexpected dst for missing key; got %q; want %q", v, "dst_")
+	}
+}
+
+func TestCacheGetNoAllocs(t *testing.T) {
+	c := New(1024 * 1024)
+	k := []byte("key")
+	c.Set(k, []byte("value"))
+	dst := make([]byte, 0, 64)
+
+	n := testing.AllocsPerRun(1000, func() {
+		dst = c.Get(dst[:0], k)
+		dst, _ = c.HasGet(dst[:0], k)
+		c.Get(dst[:0], []byte("missing"))
+		c.Has(k)
+	})
+	if n != 0 {
+		t.Fatalf("unexpected allocations in steady state; got %v; want 0", n)
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	const items = 1 << 16
+	c := New(12 * items)
+	k := []byte("\x00\x00\x00\x00")
+	v := []byte("xyza")
+	for i := 0; i < items; i++ {
+		k[0]++
+		if k[0] == 0 {
+			k[1]++
+		}
+		c.Set(k, v)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.SetBytes(items)
+	b.RunParallel(func(pb *testing.PB) {
+		var buf []byte
+		k := []byte("\x00\x00\x00\x00")
+		for pb.Next() {
+			for i := 0; i < items; i++ {
+				k[0]++
+				if k[0] == 0 {
+					k[1]++
+				}
+				buf = c.Get(buf[:0], k)
+				if string(buf) != string(v) {
+					panic(fmt.Errorf("BUG: invalid value obtained; got %q; want %q", buf, v))
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkCacheHasGet(b *testing.B) {
+	const items = 1 << 16
+	c := New(12 * items)
+	k := []byte("\x00\x00\x00\x00")
+	v := []byte("xyza")
+	for i := 0; i < items; i++ {
+		k[0]++
+		if k[0] == 0 {
+			k[1]++
+		}
+		c.Set(k, v)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.SetBytes(items)
+	b.RunParallel(func(pb *testing.PB) {
+		var buf []byte
+		var ok bool
+		k := []byte("\x00\x00\x00\x00")
+		for pb.Next() {
+			for i := 0; i < items; i++ {
+				k[0]++
+				if k[0] == 0 {
+					k[1]++
+				}
+				buf, ok = c.HasGet(buf[:0], k)
+				if !ok || string(buf) != string(v) {
+					panic(fmt.Errorf("BUG: invalid value obtained; got %q; want %q", buf, v))
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkCacheSet(b *testing.B) {
+	const items = 1 << 16
+	c := New(12 * items)
+	b.ReportAllocs()
+	b.SetBytes(items)
+	b.RunParallel(func(pb *testing.PB) {
+		k := []byte("\x00\x00\x00\x00")
+		v := []byte("xyza")
+		for pb.Next() {
+			for i := 0; i < items; i++ {
+				k[0]++
+				if k[0] == 0 {
+					k[1]++
+				}
+				c.Set(k, v)
+			}
+		}
+	})
+}
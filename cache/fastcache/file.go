@@ -0,0 +1,384 @@
+package fastcache
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+const metadataFilename = "metadata.json"
+
+type metadataJson struct {
+	BucketsCount    uint64
+	MaxBytes        uint64
+	MaxBucketChunks uint64
+}
+
+// SaveToFile saves the cache to dir.
+//
+// It may be loaded with LoadFromFile or LoadFromFileOrNew.
+// The cache may be modified while it is saved, so the saved data may miss
+// a part of the entries written during the save.
+func (c *Cache) SaveToFile(dir string) error {
+	return c.SaveToFileConcurrent(dir, 1)
+}
+
+// SaveToFileConcurrent saves the cache to dir with the given number of concurrent workers.
+//
+// GOMAXPROCS workers are used if workers <= 0.
+// It mustn't be called concurrently for the same dir.
+func (c *Cache) SaveToFileConcurrent(dir string, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(-1)
+	}
+
+	// Write the cache into a temporary directory and then replace dir with it.
+	// The old cache is moved aside before the replacement, so either dir or oldDir
+	// contains a complete cache if the process crashes in the middle of the replacement.
+	// load restores the old cache from oldDir if dir is missing.
+	// The temporary directory has a fixed name, so the directory left after a crash is removed on the next save.
+	dir = filepath.Clean(dir)
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return fmt.Errorf("cannot remove %q: %w", tmpDir, err)
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %q: %w", tmpDir, err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := c.saveBuckets(tmpDir, workers); err != nil {
+		return err
+	}
+	if err := c.saveMetadata(tmpDir); err != nil {
+		return err
+	}
+
+	oldDir := oldCacheDir(dir)
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("cannot remove %q: %w", oldDir, err)
+	}
+	if err := os.Rename(dir, oldDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot move %q to %q: %w", dir, oldDir, err)
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return fmt.Errorf("cannot move %q to %q: %w", tmpDir, dir, err)
+	}
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("cannot remove old contents at %q: %w", oldDir, err)
+	}
+	return nil
+}
+
+// oldCacheDir returns the directory, where the old cache is kept while SaveToFileConcurrent replaces dir.
+func oldCacheDir(dir string) string {
+	return dir + ".old"
+}
+
+func (c *Cache) saveMetadata(dir string) error {
+	m := metadataJson{
+		BucketsCount:    BucketsCount,
+		MaxBytes:        c.maxBytes,
+		MaxBucketChunks: uint64(len(c.buckets[0].chunks)),
+	}
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return fmt.Errorf("cannot marshal metadata: %w", err)
+	}
+	path := dir + "/" + metadataFilename
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("cannot write metadata to %q: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Cache) saveBuckets(dir string, workers int) error {
+	workCh := make(chan int, workers)
+	errCh := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var firstErr error
+			for idx := range workCh {
+				if firstErr != nil {
+					continue
+				}
+				path := bucketFilePath(dir, idx)
+				if err := c.buckets[idx].SaveToFile(path); err != nil {
+					firstErr = fmt.Errorf("cannot save bucket #%d to %q: %w", idx, path, err)
+				}
+			}
+			errCh <- firstErr
+		}()
+	}
+	for idx := range c.buckets[:] {
+		workCh <- idx
+	}
+	close(workCh)
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFromFile loads the cache saved with SaveToFile from dir.
+func LoadFromFile(dir string) (*Cache, error) {
+	return load(dir, 0)
+}
+
+// LoadFromFileOrNew loads the cache from dir.
+//
+// New(maxBytes) is returned if dir is missing, it cannot be loaded
+// or it was saved by the cache created with distinct maxBytes.
+func LoadFromFileOrNew(dir string, maxBytes int) *Cache {
+	c, err := load(dir, maxBytes)
+	if err != nil {
+		return New(maxBytes)
+	}
+	return c
+}
+
+// restoreOldCacheDir moves the old cache back to dir if SaveToFileConcurrent was interrupted
+// after moving the old cache aside.
+func restoreOldCacheDir(dir string) error {
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return nil
+	}
+	oldDir := oldCacheDir(dir)
+	if _, err := os.Stat(oldDir); err != nil {
+		return nil
+	}
+	if err := os.Rename(oldDir, dir); err != nil {
+		return fmt.Errorf("cannot restore the old cache from %q: %w", oldDir, err)
+	}
+	return nil
+}
+
+var errSizeMismatch = errors.New("the saved cache size doesn't match the requested size")
+
+// load loads the cache from dir. The saved size is verified against maxBytes if it is non-zero.
+func load(dir string, maxBytes int) (*Cache, error) {
+	dir = filepath.Clean(dir)
+	if err := restoreOldCacheDir(dir); err != nil {
+		return nil, err
+	}
+
+	path := dir + "/" + metadataFilename
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read metadata from %q: %w", path, err)
+	}
+	var m metadataJson
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal metadata from %q: %w", path, err)
+	}
+	if m.BucketsCount != BucketsCount {
+		return nil, fmt.Errorf("unexpected number of buckets in %q; got %d; want %d", path, m.BucketsCount, BucketsCount)
+	}
+	if m.MaxBytes == 0 {
+		return nil, fmt.Errorf("invalid zero MaxBytes in %q", path)
+	}
+	if maxBytes > 0 && m.MaxBytes != uint64(maxBytes) {
+		return nil, fmt.Errorf("%w: %q holds the cache with maxBytes=%d; want maxBytes=%d", errSizeMismatch, dir, m.MaxBytes, maxBytes)
+	}
+
+	c := New(int(m.MaxBytes))
+	if uint64(len(c.buckets[0].chunks)) != m.MaxBucketChunks {
+		return nil, fmt.Errorf("unexpected MaxBucketChunks in %q for MaxBytes=%d; got %d; want %d",
+			path, m.MaxBytes, m.MaxBucketChunks, len(c.buckets[0].chunks))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, BucketsCount)
+	workCh := make(chan int)
+	for i := 0; i < runtime.GOMAXPROCS(-1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range workCh {
+				path := bucketFilePath(dir, idx)
+				if err := c.buckets[idx].LoadFromFile(path); err != nil {
+					errs[idx] = fmt.Errorf("cannot load bucket #%d from %q: %w", idx, path, err)
+				}
+			}
+		}()
+	}
+	for idx := range c.buckets[:] {
+		workCh <- idx
+	}
+	close(workCh)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func bucketFilePath(dir string, idx int) string {
+	return fmt.Sprintf("%s/data.%d.bin", dir, idx)
+}
+
+// SaveToFile writes the bucket to path as flate-compressed chunks followed by the index map.
+func (b *bucket) SaveToFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(f, 64*1024)
+	zw, err := flate.NewWriter(bw, flate.BestSpeed)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	b.mu.RLock()
+	err = b.writeLocked(zw)
+	b.mu.RUnlock()
+
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (b *bucket) writeLocked(w io.Writer) error {
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint64(buf, b.idx)
+	buf = binary.LittleEndian.AppendUint64(buf, b.gen)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(b.chunks)))
+	for _, chunk := range b.chunks {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(chunk)))
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		buf = buf[:0]
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(b.m)))
+	for h, v := range b.m {
+		buf = binary.LittleEndian.AppendUint64(buf, h)
+		buf = binary.LittleEndian.AppendUint64(buf, v)
+		if len(buf) >= 64*1024 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// LoadFromFile reads the bucket written by SaveToFile from path.
+func (b *bucket) LoadFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr := flate.NewReader(bufio.NewReaderSize(f, 64*1024))
+	defer zr.Close()
+
+	var buf [16]byte
+	readUint64 := func() (uint64, error) {
+		if _, err := io.ReadFull(zr, buf[:8]); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(buf[:8]), nil
+	}
+
+	idx, err := readUint64()
+	if err != nil {
+		return fmt.Errorf("cannot read idx: %w", err)
+	}
+	gen, err := readUint64()
+	if err != nil {
+		return fmt.Errorf("cannot read gen: %w", err)
+	}
+	chunksCount, err := readUint64()
+	if err != nil {
+		return fmt.Errorf("cannot read chunks count: %w", err)
+	}
+	if chunksCount != uint64(len(b.chunks)) {
+		return fmt.Errorf("unexpected chunks count; got %d; want %d", chunksCount, len(b.chunks))
+	}
+	if idx > chunksCount*ChunkSize {
+		return fmt.Errorf("too big idx=%d; mustn't exceed %d", idx, chunksCount*ChunkSize)
+	}
+	chunks := make([][]byte, chunksCount)
+	for i := range chunks {
+		chunkLen, err := readUint64()
+		if err != nil {
+			return fmt.Errorf("cannot read the length of chunk #%d: %w", i, err)
+		}
+		if chunkLen > ChunkSize {
+			return fmt.Errorf("too big length of chunk #%d: %d; mustn't exceed %d", i, chunkLen, ChunkSize)
+		}
+		if chunkLen == 0 {
+			continue
+		}
+		chunk := make([]byte, chunkLen, ChunkSize)
+		if _, err := io.ReadFull(zr, chunk); err != nil {
+			return fmt.Errorf("cannot read chunk #%d: %w", i, err)
+		}
+		chunks[i] = chunk
+	}
+
+	itemsCount, err := readUint64()
+	if err != nil {
+		return fmt.Errorf("cannot read items count: %w", err)
+	}
+	// Every entry takes at least kvLenSize bytes in the chunks. The map may also hold the overwritten entries
+	// of the previous generation until the next cleanup, so it may have up to twice as many items.
+	if maxItemsCount := 2 * chunksCount * ChunkSize / kvLenSize; itemsCount > maxItemsCount {
+		return fmt.Errorf("too big items count: %d; mustn't exceed %d", itemsCount, maxItemsCount)
+	}
+	m := make(map[uint64]uint64, itemsCount)
+	for i := uint64(0); i < itemsCount; i++ {
+		if _, err := io.ReadFull(zr, buf[:]); err != nil {
+			return fmt.Errorf("cannot read item #%d: %w", i, err)
+		}
+		m[binary.LittleEndian.Uint64(buf[:8])] = binary.LittleEndian.Uint64(buf[8:])
+	}
+	if n, _ := zr.Read(buf[:1]); n > 0 {
+		return fmt.Errorf("unexpected data left after %d items", itemsCount)
+	}
+
+	b.mu.Lock()
+	b.chunks = chunks
+	b.m = m
+	b.idx = idx
+	b.gen = gen
+	b.mu.Unlock()
+	return nil
+}
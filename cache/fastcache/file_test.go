@@ -0,0 +1,206 @@
+package fastcache
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoadFile(t *testing.T) {
+	for _, workers := range []int{1, 4, 0} {
+		t.Run(fmt.Sprintf("workers_%d", workers), func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "cache")
+			const maxBytes = 10 * BucketsCount * ChunkSize
+			const itemsCount = 20000
+
+			c := New(maxBytes)
+			for i := 0; i < itemsCount; i++ {
+				c.Set([]byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d", i)))
+			}
+			c.Del([]byte("key 0"))
+			c.SetBig([]byte("big"), createValue(1<<20, 42))
+			if err := c.SaveToFileConcurrent(dir, workers); err != nil {
+				t.Fatalf("cannot save cache: %s", err)
+			}
+
+			// Save must overwrite the previous contents.
+			if err := c.SaveToFileConcurrent(dir, workers); err != nil {
+				t.Fatalf("cannot save cache again: %s", err)
+			}
+
+			c2 := LoadFromFileOrNew(dir, maxBytes)
+			if c2.Has([]byte("key 0")) {
+				t.Fatalf("unexpected deleted key found after load")
+			}
+			for i := 1; i < itemsCount; i++ {
+				k := fmt.Sprintf("key %d", i)
+				v := c2.Get(nil, []byte(k))
+				if want := fmt.Sprintf("value %d", i); string(v) != want {
+					t.Fatalf("unexpected value for %q after load; got %q; want %q", k, v, want)
+				}
+			}
+			if v := c2.GetBig(nil, []byte("big")); string(v) != string(createValue(1<<20, 42)) {
+				t.Fatalf("unexpected big value after load; got len=%d", len(v))
+			}
+
+			// The loaded cache must accept new entries.
+			c2.Set([]byte("new key"), []byte("new value"))
+			if v := c2.Get(nil, []byte("new key")); string(v) != "new value" {
+				t.Fatalf("unexpected value for new key; got %q", v)
+			}
+		})
+	}
+}
+
+func TestLoadFromFileSizeMismatch(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c := New(BucketsCount * ChunkSize)
+	c.Set([]byte("key"), []byte("value"))
+	if err := c.SaveToFile(dir); err != nil {
+		t.Fatalf("cannot save cache: %s", err)
+	}
+
+	if _, err := load(dir, 4*BucketsCount*ChunkSize); !errors.Is(err, errSizeMismatch) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errSizeMismatch)
+	}
+	c2 := LoadFromFileOrNew(dir, 4*BucketsCount*ChunkSize)
+	if c2.Has([]byte("key")) {
+		t.Fatalf("the cache with mismatched size must be rejected")
+	}
+	if n := len(c2.buckets[0].chunks); n != 4 {
+		t.Fatalf("unexpected chunks count for the new cache; got %d; want 4", n)
+	}
+
+	c3, err := LoadFromFile(dir)
+	if err != nil {
+		t.Fatalf("cannot load cache: %s", err)
+	}
+	if v := c3.Get(nil, []byte("key")); string(v) != "value" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "value")
+	}
+}
+
+func TestLoadFromFileMaxBytesMismatch(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c := New(BucketsCount * ChunkSize)
+	c.Set([]byte("key"), []byte("value"))
+	if err := c.SaveToFile(dir); err != nil {
+		t.Fatalf("cannot save cache: %s", err)
+	}
+
+	// maxBytes is rounded to the same number of chunks per bucket, but it differs from the saved one.
+	if _, err := load(dir, BucketsCount*ChunkSize-1); !errors.Is(err, errSizeMismatch) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errSizeMismatch)
+	}
+}
+
+func TestLoadFromFileInterruptedSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c := New(BucketsCount * ChunkSize)
+	c.Set([]byte("key"), []byte("value"))
+	if err := c.SaveToFile(dir); err != nil {
+		t.Fatalf("cannot save cache: %s", err)
+	}
+
+	// Simulate a crash after the old cache is moved aside.
+	if err := os.Rename(dir, oldCacheDir(dir)); err != nil {
+		t.Fatalf("cannot move the cache: %s", err)
+	}
+	c2, err := LoadFromFile(dir)
+	if err != nil {
+		t.Fatalf("cannot load the old cache: %s", err)
+	}
+	if v := c2.Get(nil, []byte("key")); string(v) != "value" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "value")
+	}
+}
+
+func TestLoadFromFileMissingOrBroken(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	if _, err := LoadFromFile(dir); err == nil {
+		t.Fatalf("expecting error for missing dir")
+	}
+	c := LoadFromFileOrNew(dir, 1024)
+	c.Set([]byte("key"), []byte("value"))
+	if err := c.SaveToFile(dir); err != nil {
+		t.Fatalf("cannot save cache: %s", err)
+	}
+
+	if err := os.WriteFile(bucketFilePath(dir, 7), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("cannot corrupt bucket file: %s", err)
+	}
+	if _, err := LoadFromFile(dir); err == nil {
+		t.Fatalf("expecting error for corrupted bucket file")
+	}
+}
+
+func TestLoadBucketTooBigItemsCount(t *testing.T) {
+	var b bucket
+	b.Init(ChunkSize)
+
+	// Write a bucket file claiming a huge number of items.
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint64(buf, 0)
+	buf = binary.LittleEndian.AppendUint64(buf, 1)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(b.chunks)))
+	for range b.chunks {
+		buf = binary.LittleEndian.AppendUint64(buf, 0)
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, 1<<60)
+	path := filepath.Join(t.TempDir(), "bucket.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("cannot create bucket file: %s", err)
+	}
+	zw, err := flate.NewWriter(f, flate.BestSpeed)
+	if err != nil {
+		t.Fatalf("cannot create flate writer: %s", err)
+	}
+	if _, err := zw.Write(buf); err != nil {
+		t.Fatalf("cannot write bucket file: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close flate writer: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("cannot close bucket file: %s", err)
+	}
+
+	if err := b.LoadFromFile(path); err == nil || !strings.Contains(err.Error(), "too big items count") {
+		t.Fatalf("expecting error for too big items count; got %v", err)
+	}
+}
+
+func TestSaveToFileRemovesTemporaryDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	// Simulate the temporary directory left after a crash.
+	tmpDir := dir + ".tmp"
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		t.Fatalf("cannot create directory: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "garbage"), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+
+	c := New(BucketsCount * ChunkSize)
+	c.Set([]byte("key"), []byte("value"))
+	if err := c.SaveToFile(dir); err != nil {
+		t.Fatalf("cannot save cache: %s", err)
+	}
+	if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+		t.Fatalf("the temporary directory %q must be removed; stat error: %v", tmpDir, err)
+	}
+	c2, err := LoadFromFile(dir)
+	if err != nil {
+		t.Fatalf("cannot load cache: %s", err)
+	}
+	if v := c2.Get(nil, []byte("key")); string(v) != "value" {
+		t.Fatalf("unexpected value; got %q; want %q", v, "value")
+	}
+}
@@ -0,0 +1,392 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	xxhash "github.com/cespare/xxhash/v2"
+	"io"
+	"lxi/cache/bigcache"
+	"lxi/mergeset"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// separator separates the escaped key from the version and the value in table items.
+	// Escaped keys never contain it.
+	separator  = 0
+	escapeByte = 1
+
+	versionLen = 8
+
+	// hotHeaderLen is the size of the dirty flag and the version stored in front of hot values.
+	hotHeaderLen = 1 + versionLen
+
+	FlushBatchSize = 1024
+
+	keyLocksCount = 512
+
+	// versionsFilename is the file in the table directory holding the upper bound for the issued versions.
+	versionsFilename = "versions"
+
+	// versionsReserveStep is the number of versions reserved in versionsFilename at once.
+	versionsReserveStep = 1 << 20
+)
+
+// TieredCache keeps hot entries in BigCache and persists them as items in mergeset.Table.
+//
+// Entries are written to the table when they are evicted from BigCache and on Close.
+// Evicted entries are served from the pending list until they become searchable in the table.
+// Misses in BigCache are looked up in the pending list and in the table, and the found entries are put back into BigCache.
+//
+// Every Set stores a new version of the entry. The table keeps only the latest version of every key
+// after merging the items, while the superseded versions may stay in the table until then.
+type TieredCache struct {
+	path string
+	hot  *bigcache.BigCache
+	tb   *mergeset.Table
+
+	// versionLock protects nextVersion and maxVersion.
+	// The versions up to maxVersion are reserved in versionsFilename before they are issued,
+	// so the versions keep growing after restart even if the clock goes backwards.
+	versionLock sync.Mutex
+	nextVersion uint64
+	maxVersion  uint64
+
+	// keyLocks serialize Set with putting the entry back into BigCache on Get miss,
+	// so Get never overwrites a newer entry with the older version.
+	keyLocks [keyLocksCount]sync.Mutex
+
+	// pending holds the evicted dirty entries, which aren't searchable in the table yet.
+	// It maps keys to hot values with the latest evicted version.
+	pendingLock sync.Mutex
+	pending     map[string][]byte
+
+	// flushLock serializes Flush calls.
+	flushLock sync.Mutex
+
+	// needFlushCh wakes up the flusher when pending reaches FlushBatchSize entries.
+	needFlushCh chan struct{}
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewTieredCache opens the table at path and creates BigCache with the given config on top of it.
+// config.OnRemove is overridden.
+func NewTieredCache(ctx context.Context, path string, config bigcache.Config) (*TieredCache, error) {
+	tb, err := mergeset.OpenTable(path, mergeset.Options{
+		PrepareBlock: dropSupersededVersions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open table: %w", err)
+	}
+	maxVersion, err := readMaxVersion(path)
+	if err != nil {
+		tb.MustClose()
+		return nil, err
+	}
+
+	tc := &TieredCache{
+		path:        path,
+		tb:          tb,
+		nextVersion: maxVersion,
+		maxVersion:  maxVersion,
+		pending:     make(map[string][]byte),
+		needFlushCh: make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	config.OnRemove = tc.onRemove
+	tc.hot = bigcache.NewBigCacheWithConfig(ctx, config)
+	tc.startFlusher()
+	return tc, nil
+}
+
+func (tc *TieredCache) Set(k, v []byte) error {
+	mu := tc.getKeyLock(k)
+	mu.Lock()
+	defer mu.Unlock()
+
+	version, err := tc.newVersion()
+	if err != nil {
+		return err
+	}
+	return tc.hot.Set(k, marshalHotValue(nil, true, version, v))
+}
+
+// newVersion returns the next version, which is bigger than all the versions issued before.
+func (tc *TieredCache) newVersion() (uint64, error) {
+	tc.versionLock.Lock()
+	defer tc.versionLock.Unlock()
+
+	if tc.nextVersion >= tc.maxVersion {
+		maxVersion := tc.nextVersion + versionsReserveStep
+		if err := writeMaxVersion(tc.path, maxVersion); err != nil {
+			return 0, err
+		}
+		tc.maxVersion = maxVersion
+	}
+	tc.nextVersion++
+	return tc.nextVersion, nil
+}
+
+// readMaxVersion reads the upper bound for the versions issued by the cache at path.
+func readMaxVersion(path string) (uint64, error) {
+	versionsPath := path + "/" + versionsFilename
+	data, err := os.ReadFile(versionsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The cache is new or it was created before the versions file was introduced,
+			// when the versions were obtained from the clock.
+			return uint64(time.Now().UnixNano()), nil
+		}
+		return 0, fmt.Errorf("cannot read %q: %w", versionsPath, err)
+	}
+	if len(data) != versionLen {
+		return 0, fmt.Errorf("unexpected size of %q; got %d bytes; want %d bytes", versionsPath, len(data), versionLen)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// writeMaxVersion atomically writes maxVersion to the versions file at path.
+func writeMaxVersion(path string, maxVersion uint64) error {
+	versionsPath := path + "/" + versionsFilename
+	tmpPath := versionsPath + ".tmp"
+	data := binary.BigEndian.AppendUint64(nil, maxVersion)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write %q: %w", tmpPath, err)
+	}
+	fs.MustSyncPath(tmpPath)
+	if err := os.Rename(tmpPath, versionsPath); err != nil {
+		return fmt.Errorf("cannot move %q to %q: %w", tmpPath, versionsPath, err)
+	}
+	fs.MustSyncPath(path)
+	return nil
+}
+
+func (tc *TieredCache) Get(k []byte) ([]byte, error) {
+	if hv, err := tc.hot.Get(k); err == nil {
+		return hv[hotHeaderLen:], nil
+	}
+
+	// The entry cannot be added to BigCache by Set under the key lock,
+	// so its latest version stays in pending or in the table until it is put back into BigCache.
+	mu := tc.getKeyLock(k)
+	mu.Lock()
+	defer mu.Unlock()
+	if hv, err := tc.hot.Get(k); err == nil {
+		return hv[hotHeaderLen:], nil
+	}
+
+	// The pending entries must be checked before the table, since they are removed
+	// from pending only after they become searchable in the table.
+	version, v, ok := tc.getPending(k)
+	if !ok {
+		var err error
+		version, v, ok, err = tc.searchTable(k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, bigcache.ErrEntryNotFound
+		}
+	}
+
+	// The entry is already persisted or pending, so it is stored as clean.
+	if err := tc.hot.Set(k, marshalHotValue(nil, false, version, v)); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Flush writes the pending evicted entries to the table and makes them searchable.
+func (tc *TieredCache) Flush() {
+	tc.flushLock.Lock()
+	defer tc.flushLock.Unlock()
+
+	tc.pendingLock.Lock()
+	items := make([][]byte, 0, len(tc.pending))
+	versions := make(map[string]uint64, len(tc.pending))
+	for k, hv := range tc.pending {
+		_, version, v := unmarshalHotValue(hv)
+		items = append(items, marshalItem(nil, []byte(k), version, v))
+		versions[k] = version
+	}
+	tc.pendingLock.Unlock()
+
+	if len(items) == 0 {
+		return
+	}
+	tc.tb.AddItems(items)
+	tc.tb.Flush()
+
+	// Drop the flushed entries from pending unless newer versions were evicted in the meantime.
+	tc.pendingLock.Lock()
+	for k, version := range versions {
+		hv, ok := tc.pending[k]
+		if !ok {
+			continue
+		}
+		if _, pendingVersion, _ := unmarshalHotValue(hv); pendingVersion == version {
+			delete(tc.pending, k)
+		}
+	}
+	tc.pendingLock.Unlock()
+}
+
+// Close writes all the dirty entries to the table, releases BigCache and closes the table.
+func (tc *TieredCache) Close() {
+	close(tc.stopCh)
+	tc.wg.Wait()
+
+	tc.hot.Range(tc.persist)
+	tc.Flush()
+	tc.hot.Close()
+	tc.tb.MustClose()
+}
+
+func (tc *TieredCache) startFlusher() {
+	tc.wg.Add(1)
+	go func() {
+		defer tc.wg.Done()
+		for {
+			select {
+			case <-tc.stopCh:
+				return
+			case <-tc.needFlushCh:
+				tc.Flush()
+			}
+		}
+	}()
+}
+
+func (tc *TieredCache) getKeyLock(k []byte) *sync.Mutex {
+	h := xxhash.Sum64(k)
+	return &tc.keyLocks[h%keyLocksCount]
+}
+
+func (tc *TieredCache) getPending(k []byte) (uint64, []byte, bool) {
+	tc.pendingLock.Lock()
+	defer tc.pendingLock.Unlock()
+
+	hv, ok := tc.pending[string(k)]
+	if !ok {
+		return 0, nil, false
+	}
+	_, version, v := unmarshalHotValue(hv)
+	return version, append([]byte{}, v...), true
+}
+
+func (tc *TieredCache) searchTable(k []byte) (uint64, []byte, bool, error) {
+	prefix := marshalKeyPrefix(nil, k)
+
+	var ts mergeset.TableSearch
+	ts.Init(tc.tb)
+	defer ts.MustClose()
+	if err := ts.FirstItemWithPrefix(prefix); err != nil {
+		if err == io.EOF {
+			return 0, nil, false, nil
+		}
+		return 0, nil, false, fmt.Errorf("cannot search for the key: %w", err)
+	}
+	item := ts.Item
+	if len(item) < len(prefix)+versionLen {
+		return 0, nil, false, nil
+	}
+	item = item[len(prefix):]
+	version := ^binary.BigEndian.Uint64(item)
+	v := append([]byte{}, item[versionLen:]...)
+	return version, v, true, nil
+}
+
+// onRemove is called by BigCache under the shard lock, so it only adds the entry to pending.
+// The pending entries are written to the table by the flusher.
+func (tc *TieredCache) onRemove(k, hv []byte) {
+	tc.persist(k, hv)
+
+	tc.pendingLock.Lock()
+	n := len(tc.pending)
+	tc.pendingLock.Unlock()
+	if n >= FlushBatchSize {
+		select {
+		case tc.needFlushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (tc *TieredCache) persist(k, hv []byte) {
+	dirty, version, _ := unmarshalHotValue(hv)
+	if !dirty {
+		return
+	}
+
+	tc.pendingLock.Lock()
+	defer tc.pendingLock.Unlock()
+	if prevHV, ok := tc.pending[string(k)]; ok {
+		if _, prevVersion, _ := unmarshalHotValue(prevHV); prevVersion >= version {
+			return
+		}
+	}
+	tc.pending[string(k)] = append([]byte{}, hv...)
+}
+
+// dropSupersededVersions leaves only the latest version of every key in the block.
+//
+// It is used as mergeset.PrepareBlockCallback. The latest version of the key is the first one,
+// since the versions are stored inverted.
+func dropSupersededVersions(data []byte, items []mergeset.Item) ([]byte, []mergeset.Item) {
+	dst := items[:0]
+	var prevPrefix []byte
+	for _, it := range items {
+		item := it.Bytes(data)
+		n := bytes.IndexByte(item, separator)
+		if n >= 0 && prevPrefix != nil && string(item[:n+1]) == string(prevPrefix) {
+			continue
+		}
+		prevPrefix = nil
+		if n >= 0 {
+			prevPrefix = item[:n+1]
+		}
+		dst = append(dst, it)
+	}
+	return data, dst
+}
+
+// marshalItem appends the table item for k with the given version and value to dst.
+// Versions are stored inverted, so the latest version of k is the first item with the k prefix.
+func marshalItem(dst, k []byte, version uint64, v []byte) []byte {
+	dst = marshalKeyPrefix(dst, k)
+	dst = binary.BigEndian.AppendUint64(dst, ^version)
+	return append(dst, v...)
+}
+
+// marshalKeyPrefix appends the escaped k followed by separator to dst.
+func marshalKeyPrefix(dst, k []byte) []byte {
+	for _, b := range k {
+		switch b {
+		case separator, escapeByte:
+			dst = append(dst, escapeByte, b+1)
+		default:
+			dst = append(dst, b)
+		}
+	}
+	return append(dst, separator)
+}
+
+func marshalHotValue(dst []byte, dirty bool, version uint64, v []byte) []byte {
+	flag := byte(0)
+	if dirty {
+		flag = 1
+	}
+	dst = append(dst, flag)
+	dst = binary.BigEndian.AppendUint64(dst, version)
+	return append(dst, v...)
+}
+
+func unmarshalHotValue(hv []byte) (bool, uint64, []byte) {
+	return hv[0] == 1, binary.BigEndian.Uint64(hv[1:hotHeaderLen]), hv[hotHeaderLen:]
+}
@@ -0,0 +1,309 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"lxi/cache/bigcache"
+	"lxi/mergeset"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMarshalKeyPrefix(t *testing.T) {
+	keys := [][]byte{
+		[]byte("a"),
+		[]byte("a\x00"),
+		[]byte("a\x00b"),
+		[]byte("a\x01"),
+		[]byte("ab"),
+		{},
+	}
+	for i, k1 := range keys {
+		p1 := marshalKeyPrefix(nil, k1)
+		if bytes.IndexByte(p1[:len(p1)-1], separator) >= 0 {
+			t.Fatalf("escaped key %q contains separator: %q", k1, p1)
+		}
+		for j, k2 := range keys {
+			if i == j {
+				continue
+			}
+			item := marshalItem(nil, k2, 1, []byte("value"))
+			if bytes.HasPrefix(item, p1) {
+				t.Fatalf("item for key %q matches prefix for key %q", k2, k1)
+			}
+		}
+	}
+}
+
+func TestMarshalItemLatestVersionFirst(t *testing.T) {
+	k := []byte("key")
+	items := [][]byte{
+		marshalItem(nil, k, 1, []byte("v1")),
+		marshalItem(nil, k, 3, []byte("v3")),
+		marshalItem(nil, k, 2, []byte("v2")),
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return string(items[i]) < string(items[j])
+	})
+	prefix := marshalKeyPrefix(nil, k)
+	v := items[0][len(prefix)+versionLen:]
+	if string(v) != "v3" {
+		t.Fatalf("unexpected first value; got %q; want %q", v, "v3")
+	}
+}
+
+func TestMarshalHotValue(t *testing.T) {
+	hv := marshalHotValue(nil, true, 123, []byte("value"))
+	dirty, version, v := unmarshalHotValue(hv)
+	if !dirty || version != 123 || string(v) != "value" {
+		t.Fatalf("unexpected unmarshaled hot value; got dirty=%v, version=%d, value=%q", dirty, version, v)
+	}
+}
+
+func TestDropSupersededVersions(t *testing.T) {
+	var items [][]byte
+	for _, k := range []string{"a", "b", "c"} {
+		for version := uint64(1); version <= 3; version++ {
+			items = append(items, marshalItem(nil, []byte(k), version, []byte(fmt.Sprintf("%s%d", k, version))))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return string(items[i]) < string(items[j])
+	})
+	var data []byte
+	var blockItems []mergeset.Item
+	for _, item := range items {
+		blockItems = append(blockItems, mergeset.Item{
+			Start: uint32(len(data)),
+			End:   uint32(len(data) + len(item)),
+		})
+		data = append(data, item...)
+	}
+
+	data, blockItems = dropSupersededVersions(data, blockItems)
+	var got []string
+	for _, it := range blockItems {
+		item := it.Bytes(data)
+		got = append(got, string(item[bytes.IndexByte(item, separator)+1+versionLen:]))
+	}
+	if s := strings.Join(got, ","); s != "a3,b3,c3" {
+		t.Fatalf("unexpected values left; got %q; want %q", s, "a3,b3,c3")
+	}
+}
+
+// newTestTieredCache creates TieredCache at path with small shards, so entries are evicted quickly.
+func newTestTieredCache(t *testing.T, path string) *TieredCache {
+	t.Helper()
+	config := bigcache.Config{
+		LifeWindow:       time.Hour,
+		InitialShardSize: 1024,
+		MaxShardSize:     1024,
+	}
+	tc, err := NewTieredCache(context.Background(), path, config)
+	if err != nil {
+		t.Fatalf("cannot create tiered cache: %s", err)
+	}
+	return tc
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key_%d", i))
+}
+
+func testValue(i, version int) []byte {
+	return []byte(fmt.Sprintf("value_%d_%d_%s", i, version, strings.Repeat("x", 400)))
+}
+
+func checkGet(t *testing.T, tc *TieredCache, k, want []byte) {
+	t.Helper()
+	v, err := tc.Get(k)
+	if err != nil {
+		t.Fatalf("cannot get %q: %s", k, err)
+	}
+	if string(v) != string(want) {
+		t.Fatalf("unexpected value for %q; got %.20q; want %.20q", k, v, want)
+	}
+}
+
+func TestTieredCacheGetAfterEviction(t *testing.T) {
+	tc := newTestTieredCache(t, t.TempDir())
+	defer tc.Close()
+
+	// The number of entries is smaller than FlushBatchSize, so the evicted entries stay in pending.
+	const entriesCount = 1000
+	for i := 0; i < entriesCount; i++ {
+		if err := tc.Set(testKey(i), testValue(i, 0)); err != nil {
+			t.Fatalf("cannot set entry: %s", err)
+		}
+	}
+	if n := tc.hot.Len(); n >= entriesCount {
+		t.Fatalf("expecting evictions from BigCache; got %d entries", n)
+	}
+	for i := 0; i < entriesCount; i++ {
+		checkGet(t, tc, testKey(i), testValue(i, 0))
+	}
+
+	// The evicted entries must be found in the table after the flush.
+	tc.Flush()
+	for i := 0; i < entriesCount; i++ {
+		checkGet(t, tc, testKey(i), testValue(i, 0))
+	}
+}
+
+func TestTieredCacheOverwriteAfterEviction(t *testing.T) {
+	tc := newTestTieredCache(t, t.TempDir())
+	defer tc.Close()
+
+	// Write every entry in a few versions, so older versions are evicted to the table
+	// and newer versions are evicted to pending or stay in BigCache.
+	const entriesCount = 2000
+	const versionsCount = 3
+	for version := 0; version < versionsCount; version++ {
+		for i := 0; i < entriesCount; i++ {
+			if err := tc.Set(testKey(i), testValue(i, version)); err != nil {
+				t.Fatalf("cannot set entry: %s", err)
+			}
+		}
+		if version == 0 {
+			tc.Flush()
+		}
+	}
+	for i := 0; i < entriesCount; i++ {
+		checkGet(t, tc, testKey(i), testValue(i, versionsCount-1))
+	}
+
+	// Evict the entries with other entries, so they are read from the table.
+	tc.Flush()
+	for i := entriesCount; i < 2*entriesCount; i++ {
+		if err := tc.Set(testKey(i), testValue(i, 0)); err != nil {
+			t.Fatalf("cannot set entry: %s", err)
+		}
+	}
+	for i := 0; i < entriesCount; i++ {
+		checkGet(t, tc, testKey(i), testValue(i, versionsCount-1))
+	}
+}
+
+func TestTieredCacheRestart(t *testing.T) {
+	path := t.TempDir()
+	tc := newTestTieredCache(t, path)
+
+	const entriesCount = 3000
+	for i := 0; i < entriesCount; i++ {
+		if err := tc.Set(testKey(i), testValue(i, 0)); err != nil {
+			t.Fatalf("cannot set entry: %s", err)
+		}
+	}
+	// Overwrite some entries, which are already evicted.
+	for i := 0; i < entriesCount; i += 10 {
+		if err := tc.Set(testKey(i), testValue(i, 1)); err != nil {
+			t.Fatalf("cannot set entry: %s", err)
+		}
+	}
+	tc.Close()
+
+	tc = newTestTieredCache(t, path)
+	defer tc.Close()
+	for i := 0; i < entriesCount; i++ {
+		version := 0
+		if i%10 == 0 {
+			version = 1
+		}
+		checkGet(t, tc, testKey(i), testValue(i, version))
+	}
+	if _, err := tc.Get(testKey(entriesCount)); err != bigcache.ErrEntryNotFound {
+		t.Fatalf("expecting bigcache.ErrEntryNotFound for missing key; got %v", err)
+	}
+}
+
+func TestTieredCacheVersionsAfterRestart(t *testing.T) {
+	path := t.TempDir()
+	tc := newTestTieredCache(t, path)
+	if err := tc.Set(testKey(0), testValue(0, 0)); err != nil {
+		t.Fatalf("cannot set entry: %s", err)
+	}
+	lastVersion := tc.nextVersion
+	tc.Close()
+
+	// The versions must keep growing after restart regardless of the clock.
+	tc = newTestTieredCache(t, path)
+	defer tc.Close()
+	version, err := tc.newVersion()
+	if err != nil {
+		t.Fatalf("cannot obtain version: %s", err)
+	}
+	if version <= lastVersion {
+		t.Fatalf("the version after restart must be bigger than %d; got %d", lastVersion, version)
+	}
+}
+
+func TestTieredCacheConcurrentSetGet(t *testing.T) {
+	tc := newTestTieredCache(t, t.TempDir())
+	defer tc.Close()
+
+	// Every writer owns its keys, so it must always read the last written version.
+	// The readers put the evicted entries back into BigCache concurrently with the writes.
+	const writersCount = 4
+	const readersCount = 4
+	const keysPerWriter = 500
+	const versionsCount = 5
+	stopCh := make(chan struct{})
+	var readersWG sync.WaitGroup
+	for r := 0; r < readersCount; r++ {
+		readersWG.Add(1)
+		go func(r int) {
+			defer readersWG.Done()
+			for i := r; ; i++ {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+				_, _ = tc.Get(testKey(i % (writersCount * keysPerWriter)))
+			}
+		}(r)
+	}
+
+	errCh := make(chan error, writersCount)
+	var writersWG sync.WaitGroup
+	for w := 0; w < writersCount; w++ {
+		writersWG.Add(1)
+		go func(w int) {
+			defer writersWG.Done()
+			for version := 0; version < versionsCount; version++ {
+				for i := w * keysPerWriter; i < (w+1)*keysPerWriter; i++ {
+					if err := tc.Set(testKey(i), testValue(i, version)); err != nil {
+						errCh <- fmt.Errorf("cannot set entry: %w", err)
+						return
+					}
+				}
+				for i := w * keysPerWriter; i < (w+1)*keysPerWriter; i++ {
+					v, err := tc.Get(testKey(i))
+					if err != nil {
+						errCh <- fmt.Errorf("cannot get %q: %w", testKey(i), err)
+						return
+					}
+					if want := testValue(i, version); string(v) != string(want) {
+						errCh <- fmt.Errorf("unexpected value for %q; got %.20q; want %.20q", testKey(i), v, want)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	writersWG.Wait()
+	close(stopCh)
+	readersWG.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < writersCount*keysPerWriter; i++ {
+		checkGet(t, tc, testKey(i), testValue(i, versionsCount-1))
+	}
+}
@@ -1,7 +1,13 @@
 package mergeset
 
 import (
+	"fmt"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"io"
+	"path/filepath"
 	"sync"
 )
 
@@ -9,6 +15,37 @@ type blockStreamReader struct {
 	Block           inMemoryBlock
 	isInMemoryBlock bool
 	currItemIdx     int
+
+	// path is the part path for file parts.
+	path string
+	ph   partHeader
+
+	mrs   []metaindexRow
+	mrIdx int
+
+	bhs   []blockHeader
+	bhIdx int
+
+	indexReader filestream.ReadCloser
+	itemsReader filestream.ReadCloser
+	lensReader  filestream.ReadCloser
+
+	bh *blockHeader
+	sb storageBlock
+
+	itemsRead        uint64
+	blocksRead       uint64
+	firstItemChecked bool
+
+	// Offsets of the next blocks in the part files. They are used for verifying
+	// that the blocks are stored sequentially.
+	indexBlockOffset uint64
+	itemsBlockOffset uint64
+	lensBlockOffset  uint64
+
+	packedBuf   []byte
+	unpackedBuf []byte
+
 	// The last error.
 	err error
 }
@@ -20,6 +57,89 @@ func (bsr *blockStreamReader) InitFromBlockStreamReader(ib *inMemoryBlock) {
 	bsr.isInMemoryBlock = true
 }
 
+// InitFromInMemoryPart initializes bsr for reading the blocks of mp in order.
+func (bsr *blockStreamReader) InitFromInMemoryPart(mp *inMemoryPart) {
+	bsr.reset()
+
+	var err error
+	bsr.mrs, err = unmarshalMetaindexRows(bsr.mrs[:0], mp.metaindexData.NewReader())
+	if err != nil {
+		logger.Panicf("BUG: cannot unmarshal metaindex rows from inMemoryPart: %s", err)
+	}
+
+	bsr.ph.CopyFrom(&mp.ph)
+	bsr.indexReader = mp.indexData.NewReader()
+	bsr.itemsReader = mp.itemsData.NewReader()
+	bsr.lensReader = mp.lensData.NewReader()
+}
+
+// InitFromFilePart initializes bsr for reading the blocks of the part at path in order.
+//
+// MustClose must be called when bsr is no longer needed.
+func (bsr *blockStreamReader) InitFromFilePart(path string) error {
+	bsr.reset()
+
+	path = filepath.Clean(path)
+	if err := bsr.ph.ParseFromPath(path); err != nil {
+		return fmt.Errorf("cannot parse part header from %q: %w", path, err)
+	}
+	bsr.path = path
+
+	metaindexPath := path + "/metaindex.bin"
+	metaindexFile, err := filestream.Open(metaindexPath, true)
+	if err != nil {
+		return fmt.Errorf("cannot open metaindex file in stream mode: %w", err)
+	}
+	bsr.mrs, err = unmarshalMetaindexRows(bsr.mrs[:0], metaindexFile)
+	metaindexFile.MustClose()
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal metaindex rows from %q: %w", metaindexPath, err)
+	}
+
+	indexPath := path + "/index.bin"
+	indexFile, err := filestream.Open(indexPath, true)
+	if err != nil {
+		return fmt.Errorf("cannot open index file in stream mode: %w", err)
+	}
+
+	itemsPath := path + "/items.bin"
+	itemsFile, err := filestream.Open(itemsPath, true)
+	if err != nil {
+		indexFile.MustClose()
+		return fmt.Errorf("cannot open items file in stream mode: %w", err)
+	}
+
+	lensPath := path + "/lens.bin"
+	lensFile, err := filestream.Open(lensPath, true)
+	if err != nil {
+		indexFile.MustClose()
+		itemsFile.MustClose()
+		return fmt.Errorf("cannot open lens file in stream mode: %w", err)
+	}
+
+	bsr.indexReader = indexFile
+	bsr.itemsReader = itemsFile
+	bsr.lensReader = lensFile
+	return nil
+}
+
+// MustClose closes the files opened by InitFromFilePart.
+func (bsr *blockStreamReader) MustClose() {
+	if bsr.path != "" {
+		bsr.indexReader.MustClose()
+		bsr.itemsReader.MustClose()
+		bsr.lensReader.MustClose()
+	}
+	bsr.reset()
+}
+
+func (bsr *blockStreamReader) String() string {
+	if bsr.path != "" {
+		return bsr.path
+	}
+	return "inMemoryPart"
+}
+
 func (bsr *blockStreamReader) CurrItem() string {
 	return bsr.Block.items[bsr.currItemIdx].String(bsr.Block.data)
 
@@ -29,39 +149,170 @@ func (bsr *blockStreamReader) reset() {
 	bsr.Block.Reset()
 	bsr.isInMemoryBlock = false
 	bsr.currItemIdx = 0
-	//bsr.path = ""
-	//bsr.ph.Reset()
-	//bsr.mrs = nil
-	//bsr.mrIdx = 0
-	//bsr.bhs = bsr.bhs[:0]
-	//bsr.bhIdx = 0
-	//
-	//bsr.indexReader = nil
-	//bsr.itemsReader = nil
-	//bsr.lensReader = nil
-	//
-	//bsr.bh = nil
-	//bsr.sb.Reset()
-	//
-	//bsr.itemsRead = 0
-	//bsr.blocksRead = 0
-	//bsr.firstItemChecked = false
-	//
-	//bsr.packedBuf = bsr.packedBuf[:0]
-	//bsr.unpackedBuf = bsr.unpackedBuf[:0]
+	bsr.path = ""
+	bsr.ph.Reset()
+	bsr.mrs = bsr.mrs[:0]
+	bsr.mrIdx = 0
+	bsr.bhs = bsr.bhs[:0]
+	bsr.bhIdx = 0
+
+	bsr.indexReader = nil
+	bsr.itemsReader = nil
+	bsr.lensReader = nil
+
+	bsr.bh = nil
+	bsr.sb.Reset()
+
+	bsr.itemsRead = 0
+	bsr.blocksRead = 0
+	bsr.firstItemChecked = false
+
+	bsr.indexBlockOffset = 0
+	bsr.itemsBlockOffset = 0
+	bsr.lensBlockOffset = 0
+
+	bsr.packedBuf = bsr.packedBuf[:0]
+	bsr.unpackedBuf = bsr.unpackedBuf[:0]
 
 	bsr.err = nil
 }
 
+// Error returns the last error occurred in Next.
+func (bsr *blockStreamReader) Error() error {
+	if bsr.err == io.EOF {
+		return nil
+	}
+	return bsr.err
+}
 
+// Next reads the next block into bsr.Block.
+//
+// It returns false when there are no more blocks or on error, which is returned by Error.
 func (bsr *blockStreamReader) Next() bool {
+	if bsr.err != nil {
+		return false
+	}
 	if bsr.isInMemoryBlock {
+		// The block is already in bsr.Block.
 		bsr.err = io.EOF
 		return true
 	}
+
+	if bsr.bhIdx >= len(bsr.bhs) {
+		// The current index block is over. Read the next one.
+		if err := bsr.readNextBHS(); err != nil {
+			if err == io.EOF {
+				err = bsr.checkReadCounts()
+			} else {
+				err = fmt.Errorf("cannot read the next index block: %w", err)
+			}
+			bsr.err = bsr.wrapError(err)
+			return false
+		}
+	}
+
+	bsr.bh = &bsr.bhs[bsr.bhIdx]
+	bsr.bhIdx++
+
+	if bsr.bh.itemsBlockOffset != bsr.itemsBlockOffset {
+		bsr.err = bsr.wrapError(fmt.Errorf("unexpected itemsBlockOffset; got %d; want %d", bsr.bh.itemsBlockOffset, bsr.itemsBlockOffset))
+		return false
+	}
+	if bsr.bh.lensBlockOffset != bsr.lensBlockOffset {
+		bsr.err = bsr.wrapError(fmt.Errorf("unexpected lensBlockOffset; got %d; want %d", bsr.bh.lensBlockOffset, bsr.lensBlockOffset))
+		return false
+	}
+
+	bsr.sb.itemsData = bytesutil.ResizeNoCopyMayOverallocate(bsr.sb.itemsData, int(bsr.bh.itemsBlockSize))
+	if _, err := io.ReadFull(bsr.itemsReader, bsr.sb.itemsData); err != nil {
+		bsr.err = bsr.wrapError(fmt.Errorf("cannot read compressed items block with size %d: %w", bsr.bh.itemsBlockSize, err))
+		return false
+	}
+	bsr.itemsBlockOffset += uint64(bsr.bh.itemsBlockSize)
+
+	bsr.sb.lensData = bytesutil.ResizeNoCopyMayOverallocate(bsr.sb.lensData, int(bsr.bh.lensBlockSize))
+	if _, err := io.ReadFull(bsr.lensReader, bsr.sb.lensData); err != nil {
+		bsr.err = bsr.wrapError(fmt.Errorf("cannot read compressed lens block with size %d: %w", bsr.bh.lensBlockSize, err))
+		return false
+	}
+	bsr.lensBlockOffset += uint64(bsr.bh.lensBlockSize)
+
+	if err := bsr.Block.UnmarshalData(&bsr.sb, bsr.bh.firstItem, bsr.bh.commonPrefix, bsr.bh.itemsCount, bsr.bh.marshalType); err != nil {
+		bsr.err = bsr.wrapError(fmt.Errorf("cannot unmarshal inMemoryBlock from storage block with %d items: %w", bsr.bh.itemsCount, err))
+		return false
+	}
+	bsr.blocksRead++
+	bsr.itemsRead += uint64(len(bsr.Block.items))
+	if bsr.itemsRead > bsr.ph.itemsCount {
+		bsr.err = bsr.wrapError(fmt.Errorf("too many items read; got %d; cannot be bigger than %d", bsr.itemsRead, bsr.ph.itemsCount))
+		return false
+	}
+	if !bsr.firstItemChecked {
+		bsr.firstItemChecked = true
+		if firstItem := bsr.Block.items[0].String(bsr.Block.data); firstItem != string(bsr.ph.firstItem) {
+			bsr.err = bsr.wrapError(fmt.Errorf("unexpected first item; got %q; want %q", firstItem, bsr.ph.firstItem))
+			return false
+		}
+	}
+	bsr.currItemIdx = 0
 	return true
 }
 
+// checkReadCounts verifies the counts read by bsr against the part header after the last block is read.
+func (bsr *blockStreamReader) checkReadCounts() error {
+	if bsr.itemsRead != bsr.ph.itemsCount {
+		return fmt.Errorf("unexpected number of items read; got %d; want %d", bsr.itemsRead, bsr.ph.itemsCount)
+	}
+	if bsr.blocksRead != bsr.ph.blocksCount {
+		return fmt.Errorf("unexpected number of blocks read; got %d; want %d", bsr.blocksRead, bsr.ph.blocksCount)
+	}
+	if bsr.blocksRead > 0 {
+		items := bsr.Block.items
+		if lastItem := items[len(items)-1].String(bsr.Block.data); lastItem != string(bsr.ph.lastItem) {
+			return fmt.Errorf("unexpected last item; got %q; want %q", lastItem, bsr.ph.lastItem)
+		}
+	}
+	return io.EOF
+}
+
+func (bsr *blockStreamReader) wrapError(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	return fmt.Errorf("error when reading %s: %w", bsr, err)
+}
+
+func (bsr *blockStreamReader) readNextBHS() error {
+	if bsr.mrIdx >= len(bsr.mrs) {
+		return io.EOF
+	}
+	mr := &bsr.mrs[bsr.mrIdx]
+	bsr.mrIdx++
+
+	if mr.indexBlockOffset != bsr.indexBlockOffset {
+		return fmt.Errorf("unexpected indexBlockOffset; got %d; want %d", mr.indexBlockOffset, bsr.indexBlockOffset)
+	}
+
+	bsr.packedBuf = bytesutil.ResizeNoCopyMayOverallocate(bsr.packedBuf, int(mr.indexBlockSize))
+	if _, err := io.ReadFull(bsr.indexReader, bsr.packedBuf); err != nil {
+		return fmt.Errorf("cannot read compressed index block with size %d: %w", mr.indexBlockSize, err)
+	}
+	bsr.indexBlockOffset += uint64(mr.indexBlockSize)
+
+	var err error
+	bsr.unpackedBuf, err = encoding.DecompressZSTD(bsr.unpackedBuf[:0], bsr.packedBuf)
+	if err != nil {
+		return fmt.Errorf("cannot decompress index block with size %d: %w", mr.indexBlockSize, err)
+	}
+
+	bsr.bhs, err = unmarshalBlockHeadersNoCopy(bsr.bhs[:0], bsr.unpackedBuf, int(mr.blockHeadersCount))
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal block headers from index block (offset=%d, size=%d): %w", mr.indexBlockOffset, mr.indexBlockSize, err)
+	}
+	bsr.bhIdx = 0
+	return nil
+}
+
 func getBlockStreamReader() *blockStreamReader {
 	v := bsrPool.Get()
 	if v == nil {
@@ -70,8 +321,8 @@ func getBlockStreamReader() *blockStreamReader {
 	return v.(*blockStreamReader)
 }
 
-
 func putBlockStreamReader(bsr *blockStreamReader) {
+	bsr.reset()
 	bsrPool.Put(bsr)
 }
 
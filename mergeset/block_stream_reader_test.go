@@ -0,0 +1,121 @@
+package mergeset
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newTestFilePart adds itemsCount random items to a new table at path and merges them into a single file part.
+// The table is closed before returning the path to the part and the sorted items.
+// It returns the sorted items.
+func newTestFilePart(t *testing.T, path string, itemsCount int) (string, []string) {
+	t.Helper()
+	tb := mustOpenTableNoMerges(t, path, Options{})
+
+	padding := strings.Repeat("x", 1000)
+	var items [][]byte
+	var sortedItems []string
+	for i := 0; i < itemsCount; i++ {
+		item := fmt.Sprintf("item_%08d_%s", rand.Intn(1e8), padding)
+		items = append(items, []byte(item))
+		sortedItems = append(sortedItems, item)
+	}
+	sort.Strings(sortedItems)
+
+	tb.AddItems(items)
+	tb.mergeInMemoryParts(0, nil)
+	if len(tb.parts) != 1 || tb.parts[0].mp != nil {
+		t.Fatalf("expecting a single file part")
+	}
+	partPath := tb.parts[0].p.path
+	tb.MustClose()
+	return partPath, sortedItems
+}
+
+func readBlockStreamItems(bsr *blockStreamReader) ([]string, error) {
+	var items []string
+	for bsr.Next() {
+		for _, it := range bsr.Block.items {
+			items = append(items, string(it.Bytes(bsr.Block.data)))
+		}
+	}
+	return items, bsr.Error()
+}
+
+func TestBlockStreamReaderFilePart(t *testing.T) {
+	partPath, sortedItems := newTestFilePart(t, t.TempDir(), 5000)
+
+	var bsr blockStreamReader
+	if err := bsr.InitFromFilePart(partPath); err != nil {
+		t.Fatalf("cannot open block stream reader: %s", err)
+	}
+	defer bsr.MustClose()
+
+	items, err := readBlockStreamItems(&bsr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(items) != len(sortedItems) {
+		t.Fatalf("unexpected number of items read; got %d; want %d", len(items), len(sortedItems))
+	}
+	for i := range items {
+		if items[i] != sortedItems[i] {
+			t.Fatalf("unexpected item #%d; got %.20q; want %.20q", i, items[i], sortedItems[i])
+		}
+	}
+	if bsr.blocksRead != bsr.ph.blocksCount {
+		t.Fatalf("unexpected number of blocks read; got %d; want %d", bsr.blocksRead, bsr.ph.blocksCount)
+	}
+}
+
+func TestBlockStreamReaderInMemoryPart(t *testing.T) {
+	var ib inMemoryBlock
+	for _, item := range []string{"c", "a", "b"} {
+		ib.Add([]byte(item))
+	}
+	var mp inMemoryPart
+	mp.Init(&ib)
+
+	var bsr blockStreamReader
+	bsr.InitFromInMemoryPart(&mp)
+	items, err := readBlockStreamItems(&bsr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := strings.Join(items, ","); s != "a,b,c" {
+		t.Fatalf("unexpected items; got %q; want %q", s, "a,b,c")
+	}
+}
+
+func TestBlockStreamReaderCorruptedPart(t *testing.T) {
+	path := t.TempDir()
+	partPath, _ := newTestFilePart(t, path, 5000)
+
+	// Claim more items in the part header than the part contains.
+	var ph partHeader
+	if err := ph.ParseFromPath(partPath); err != nil {
+		t.Fatalf("cannot parse part header: %s", err)
+	}
+	ph.itemsCount++
+	corruptedPath := ph.Path(path, 0)
+	if err := os.Rename(partPath, corruptedPath); err != nil {
+		t.Fatalf("cannot rename the part: %s", err)
+	}
+	if err := ph.WriteMetadata(corruptedPath); err != nil {
+		t.Fatalf("cannot write metadata: %s", err)
+	}
+
+	var bsr blockStreamReader
+	if err := bsr.InitFromFilePart(corruptedPath); err != nil {
+		t.Fatalf("cannot open block stream reader: %s", err)
+	}
+	defer bsr.MustClose()
+	_, err := readBlockStreamItems(&bsr)
+	if err == nil || !strings.Contains(err.Error(), "unexpected number of items read") {
+		t.Fatalf("expecting an error about the number of items read; got %v", err)
+	}
+}
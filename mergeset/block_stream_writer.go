@@ -10,11 +10,10 @@ import (
 )
 
 type blockStreamWriter struct {
-	sb storageBlock
-	bh blockHeader
-	mr metaindexRow
-	path          string
-
+	sb   storageBlock
+	bh   blockHeader
+	mr   metaindexRow
+	path string
 
 	compressLevel int
 
@@ -25,7 +24,6 @@ type blockStreamWriter struct {
 
 	mrFirstItemCaught bool
 
-
 	unpackedIndexBlockBuf []byte
 	packedIndexBlockBuf   []byte
 
@@ -38,6 +36,7 @@ type blockStreamWriter struct {
 }
 
 func (bsw *blockStreamWriter) InitFromInMemoryPart(mp *inMemoryPart) {
+	bsw.reset()
 	bsw.compressLevel = -5
 	bsw.metaindexWriter = &mp.metaindexData
 	bsw.indexWriter = &mp.indexData
@@ -135,6 +134,24 @@ func (bsw *blockStreamWriter) InitFromFilePart(path string, nocache bool, compre
 
 }
 
+// MustClose writes the remaining index data and the metaindex and closes the underlying writers.
+func (bsw *blockStreamWriter) MustClose() {
+	bsw.flushIndexData()
+
+	bsw.packedMetaindexBuf = encoding.CompressZSTDLevel(bsw.packedMetaindexBuf[:0], bsw.unpackedMetaindexBuf, bsw.compressLevel)
+	fs.MustWriteData(bsw.metaindexWriter, bsw.packedMetaindexBuf)
+
+	bsw.metaindexWriter.MustClose()
+	bsw.indexWriter.MustClose()
+	bsw.itemsWriter.MustClose()
+	bsw.lensWriter.MustClose()
+
+	if bsw.path != "" {
+		fs.MustSyncPath(bsw.path)
+	}
+	bsw.reset()
+}
+
 func (bsw *blockStreamWriter) reset() {
 	bsw.compressLevel = 0
 	bsw.path = ""
@@ -13,7 +13,7 @@ import (
 
 const (
 	IbPoolSize           = 128
-	MaxInMemoryBlockSize = 16
+	MaxInMemoryBlockSize = 64 * 1024
 )
 
 const (
@@ -131,17 +131,16 @@ func (ib *inMemoryBlock) updateCommonPrefixUnsorted() {
 func (ib *inMemoryBlock) MarshalSortedData(sb *storageBlock, firstItemDst, commonPrefixDst []byte, compressLevel int) ([]byte, []byte, uint32, marshalType) {
 	ib.isSorted()
 	ib.updateCommonPrefixSorted()
-	return ib.marshalData(sb,firstItemDst,commonPrefixDst,compressLevel)
+	return ib.marshalData(sb, firstItemDst, commonPrefixDst, compressLevel)
 }
 
-func (ib *inMemoryBlock) marshalData(sb *storageBlock, firstItemDst, commonPrefixDst []byte, compressLevel int)  ([]byte, []byte, uint32, marshalType){
+func (ib *inMemoryBlock) marshalData(sb *storageBlock, firstItemDst, commonPrefixDst []byte, compressLevel int) ([]byte, []byte, uint32, marshalType) {
 
 	data := ib.data
 	firstItem := ib.items[0].Bytes(data)
 	firstItemDst = append(firstItemDst, firstItem...)
 	commonPrefixDst = append(commonPrefixDst, ib.commonPrefix...)
 
-
 	if len(data)-len(ib.commonPrefix)*len(ib.items) < 64 || len(ib.items) < 2 {
 		// Use plain encoding form small block, since it is cheaper.
 		ib.marshalDataPlain(sb)
@@ -234,7 +233,7 @@ func (ib *inMemoryBlock) updateCommonPrefixSorted() {
 	}
 	data := ib.data
 	cp := items[0].Bytes(data)
-	if len(items) > 1{
+	if len(items) > 1 {
 		cpLen := commonPrefixLen(cp, items[len(items)-1].Bytes(data))
 		cp = cp[:cpLen]
 	}
@@ -245,7 +244,7 @@ var bbPool bytesutil.ByteBufferPool
 
 func (ib *inMemoryBlock) UnmarshalData(sb *storageBlock, firstItem, commonPrefix []byte, itemsCount uint32, mt marshalType) error {
 
-	ib.commonPrefix = append(ib.commonPrefix, commonPrefix...)
+	ib.commonPrefix = append(ib.commonPrefix[:0], commonPrefix...)
 
 	switch mt {
 	case marshalTypePlain:
@@ -276,6 +275,7 @@ func (ib *inMemoryBlock) UnmarshalData(sb *storageBlock, firstItem, commonPrefix
 
 	prefixLens := lb.lens[:itemsCount]
 	lens := lb.lens[itemsCount:]
+	prefixLens[0] = 0
 
 	is := encoding.GetUint64s(int(itemsCount) - 1)
 	defer encoding.PutUint64s(is)
@@ -3,6 +3,7 @@ package mergeset
 import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 )
 
 type inMemoryPart struct {
@@ -25,7 +26,6 @@ func (mp *inMemoryPart) Init(ib *inMemoryBlock) {
 	compressLevel := -5
 	mp.bh.firstItem, mp.bh.commonPrefix, mp.bh.itemsCount, mp.bh.marshalType = ib.MarshalUnsortedData(sb, mp.bh.firstItem[:0], mp.bh.commonPrefix[:0], compressLevel)
 
-
 	mp.ph.itemsCount = uint64(len(ib.items))
 	mp.ph.blocksCount = 1
 	mp.ph.firstItem = append(mp.ph.firstItem[:0], ib.items[0].String(ib.data)...)
@@ -54,8 +54,17 @@ func (mp *inMemoryPart) Init(ib *inMemoryBlock) {
 
 var inmemoryPartBytePool bytesutil.ByteBufferPool
 
+// NewPart returns a part, which reads its data from mp.
 func (mp *inMemoryPart) NewPart() *part {
-	return nil
+	p, err := newPart(&mp.ph, "", mp.size(), mp.metaindexData.NewReader(), &mp.indexData, &mp.itemsData, &mp.lensData)
+	if err != nil {
+		logger.Panicf("BUG: cannot create a part from inMemoryPart: %s", err)
+	}
+	return p
+}
+
+func (mp *inMemoryPart) size() uint64 {
+	return uint64(len(mp.metaindexData.B) + len(mp.indexData.B) + len(mp.itemsData.B) + len(mp.lensData.B))
 }
 
 func (mp *inMemoryPart) Reset() {
@@ -67,4 +76,4 @@ func (mp *inMemoryPart) Reset() {
 	mp.indexData.Reset()
 	mp.itemsData.Reset()
 	mp.lensData.Reset()
-}
\ No newline at end of file
+}
@@ -2,17 +2,31 @@ package mergeset
 
 import (
 	"container/heap"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
+// errForciblyStopped is returned from mergeBlockStreams when stopCh is closed.
+var errForciblyStopped = errors.New("forcibly stopped")
+
 func mergeBlockStreams(ph *partHeader, bsw *blockStreamWriter, bsrs []*blockStreamReader,
-	prepareBlock PrepareBlockCallback, stopCh <-chan struct{}, itemsMerged *uint64) {
+	prepareBlock PrepareBlockCallback, stopCh <-chan struct{}, itemsMerged *uint64) error {
 	bsm := bsmPool.Get().(*blockStreamMerger)
-	bsm.Init(bsrs, prepareBlock)
+	defer func() {
+		bsm.reset()
+		bsmPool.Put(bsm)
+	}()
 
-	bsm.Merge(bsw, ph, stopCh, itemsMerged)
-	bsmPool.Put(bsm)
-	bsm.reset()
+	if err := bsm.Init(bsrs, prepareBlock); err != nil {
+		return fmt.Errorf("cannot initialize blockStreamMerger: %w", err)
+	}
+	err := bsm.Merge(bsw, ph, stopCh, itemsMerged)
+	if err == nil || err == errForciblyStopped {
+		return err
+	}
+	return fmt.Errorf("cannot merge %d block streams: %w", len(bsrs), err)
 }
 
 type PrepareBlockCallback func(data []byte, items []Item) ([]byte, []Item)
@@ -24,19 +38,26 @@ var bsmPool = &sync.Pool{
 }
 
 type blockStreamMerger struct {
-	bsrHeap bsrHeap
-	ib      inMemoryBlock
+	bsrHeap           bsrHeap
+	ib                inMemoryBlock
 	phFirstItemCaught bool
-	prepareBlock PrepareBlockCallback
+	prepareBlock      PrepareBlockCallback
 }
 
-func (bsm *blockStreamMerger) Init(bsrs []*blockStreamReader, prepareBlock PrepareBlockCallback) {
+func (bsm *blockStreamMerger) Init(bsrs []*blockStreamReader, prepareBlock PrepareBlockCallback) error {
+	bsm.reset()
+	bsm.prepareBlock = prepareBlock
 	for _, bsr := range bsrs {
-		bsm.bsrHeap = append(bsm.bsrHeap, bsr)
+		if bsr.Next() {
+			bsm.bsrHeap = append(bsm.bsrHeap, bsr)
+		}
+		if err := bsr.Error(); err != nil {
+			return fmt.Errorf("cannot obtain the first block: %w", err)
+		}
 	}
 
 	heap.Init(&bsm.bsrHeap)
-
+	return nil
 }
 
 func (bsm *blockStreamMerger) reset() {
@@ -83,25 +104,43 @@ again:
 	}
 
 	if bsr.currItemIdx == len(bsr.Block.items) {
-		//if bsr.Next() {
-		//	heap.Fix(&bsm.bsrHeap, 0)
-		//	goto again
-		//}
+		// bsr.Block is fully read. Proceed to the next block.
+		select {
+		case <-stopCh:
+			return errForciblyStopped
+		default:
+		}
+		if bsr.Next() {
+			heap.Fix(&bsm.bsrHeap, 0)
+			goto again
+		}
+		if err := bsr.Error(); err != nil {
+			return fmt.Errorf("cannot read the next block: %w", err)
+		}
 		heap.Pop(&bsm.bsrHeap)
 		goto again
 	}
 
-	heap.Fix(&bsm.bsrHeap,0)
+	heap.Fix(&bsm.bsrHeap, 0)
 	goto again
 }
 
 func (bsm *blockStreamMerger) flushIB(bsw *blockStreamWriter, ph *partHeader, itemsMerged *uint64) {
+	if len(bsm.ib.items) == 0 {
+		return
+	}
+	if bsm.prepareBlock != nil {
+		bsm.ib.data, bsm.ib.items = bsm.prepareBlock(bsm.ib.data, bsm.ib.items)
+		if len(bsm.ib.items) == 0 {
+			// Nothing to flush.
+			bsm.ib.Reset()
+			return
+		}
+	}
 	items := bsm.ib.items
 	data := bsm.ib.data
+	atomic.AddUint64(itemsMerged, uint64(len(items)))
 
-	if len(items) == 0 {
-		return
-	}
 	ph.itemsCount += uint64(len(items))
 	if !bsm.phFirstItemCaught {
 		ph.firstItem = append(ph.firstItem[:0], items[0].String(data)...)
@@ -111,11 +150,9 @@ func (bsm *blockStreamMerger) flushIB(bsw *blockStreamWriter, ph *partHeader, it
 
 	bsw.WriteBlock(&bsm.ib)
 	bsm.ib.Reset()
-	ph.blocksCount ++
+	ph.blocksCount++
 }
 
-
-
 type bsrHeap []*blockStreamReader
 
 func (bh bsrHeap) getNextReader() *blockStreamReader {
@@ -18,28 +18,25 @@ func (mr *metaindexRow) Unmarshal(src []byte) ([]byte, error) {
 
 	tail, fi, err := encoding.UnmarshalBytes(src)
 	if err != nil {
-		fmt.Println(err)
-		return nil, err
+		return nil, fmt.Errorf("cannot unmarshal firstItem: %w", err)
 	}
 	mr.firstItem = append(mr.firstItem[:0], fi...)
 	src = tail
 
 	if len(src) < 4 {
-		fmt.Println(err)
-		return nil, err
+		return nil, fmt.Errorf("cannot unmarshal blockHeadersCount from %d bytes; need at least 4 bytes", len(src))
 	}
 	mr.blockHeadersCount = encoding.UnmarshalUint32(src)
 	src = src[4:]
 
 	if len(src) < 8 {
-		return nil, err
+		return nil, fmt.Errorf("cannot unmarshal indexBlockOffset from %d bytes; need at least 8 bytes", len(src))
 	}
 	mr.indexBlockOffset = encoding.UnmarshalUint64(src)
 	src = src[8:]
 
 	if len(src) < 4 {
-		fmt.Println(err)
-		return nil, err
+		return nil, fmt.Errorf("cannot unmarshal indexBlockSize from %d bytes; need at least 4 bytes", len(src))
 	}
 	mr.indexBlockSize = encoding.UnmarshalUint32(src)
 	src = src[4:]
@@ -102,4 +99,4 @@ func (mr *metaindexRow) Reset() {
 	mr.blockHeadersCount = 0
 	mr.indexBlockOffset = 0
 	mr.indexBlockSize = 0
-}
\ No newline at end of file
+}
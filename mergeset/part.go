@@ -1,8 +1,11 @@
 package mergeset
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"os"
 )
 
 type part struct {
@@ -20,39 +23,71 @@ type part struct {
 }
 
 func openFilePart(path string) (*part, error) {
-
 	var ph partHeader
-
 	if err := ph.ParseFromPath(path); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot parse part header for part %q: %w", path, err)
 	}
 
-	metaIndexFile, err := filestream.Open(path+"/metaindex.bin", true)
+	metaindexPath := path + "/metaindex.bin"
+	metaindexFile, err := filestream.Open(metaindexPath, true)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot open metaindex file for part %q: %w", path, err)
+	}
+	metaindexSize, err := fileSize(metaindexPath)
+	if err != nil {
+		metaindexFile.MustClose()
+		return nil, fmt.Errorf("cannot obtain metaindex file size for part %q: %w", path, err)
 	}
-	metaIndexSize := fs.MustFileSize(path + "/metaindex.bin")
-
-	indexFile := fs.MustOpenReaderAt(path + "/index.bin")
-	indexSize := fs.MustFileSize(path + "/index.bin")
-
-	itemsFile := fs.MustOpenReaderAt(path + "/items.bin")
-	itemsSize := fs.MustFileSize(path + "/items.bin")
 
-	lenFile := fs.MustOpenReaderAt(path + "/lens.bin")
-	lenSize := fs.MustFileSize(path + "/lens.bin")
+	var files []fs.MustReadAtCloser
+	mustCloseFiles := func() {
+		metaindexFile.MustClose()
+		for _, f := range files {
+			f.MustClose()
+		}
+	}
+	size := metaindexSize
+	for _, name := range []string{"index.bin", "items.bin", "lens.bin"} {
+		filePath := path + "/" + name
+		f, err := fs.OpenReaderAt(filePath)
+		if err != nil {
+			mustCloseFiles()
+			return nil, fmt.Errorf("cannot open %s for part %q: %w", name, path, err)
+		}
+		files = append(files, f)
+		n, err := fileSize(filePath)
+		if err != nil {
+			mustCloseFiles()
+			return nil, fmt.Errorf("cannot obtain %s size for part %q: %w", name, path, err)
+		}
+		size += n
+	}
 
-	size := metaIndexSize + indexSize + itemsSize + lenSize
-	return newPart(&ph, path, size, metaIndexFile, indexFile, itemsFile, lenFile)
+	p, err := newPart(&ph, path, size, metaindexFile, files[0], files[1], files[2])
+	if err != nil {
+		for _, f := range files {
+			f.MustClose()
+		}
+		return nil, fmt.Errorf("cannot open part %q: %w", path, err)
+	}
+	return p, nil
+}
 
+func fileSize(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fi.Size()), nil
 }
 
+// newPart creates a part from the given files. metaindexReader is closed by newPart.
 func newPart(ph *partHeader, path string, size uint64, metaindexReader filestream.ReadCloser, indexFile, itemsFile, lensFile fs.MustReadAtCloser) (*part, error) {
 	mrs, err := unmarshalMetaindexRows(nil, metaindexReader)
+	metaindexReader.MustClose()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot unmarshal metaindex rows: %w", err)
 	}
-	metaindexReader.MustClose()
 
 	var p part
 	p.path = path
@@ -65,6 +100,29 @@ func newPart(ph *partHeader, path string, size uint64, metaindexReader filestrea
 	return &p, nil
 }
 
+// hasItemsWithPrefix returns false if p cannot contain items starting with prefix.
+func (p *part) hasItemsWithPrefix(prefix []byte) bool {
+	if string(p.ph.lastItem) < string(prefix) {
+		return false
+	}
+	return string(p.ph.firstItem) <= string(prefix) || bytes.HasPrefix(p.ph.firstItem, prefix)
+}
+
+// hasItemsInRange returns false if p cannot contain items in the [start, end) range. Empty end means no upper bound.
+func (p *part) hasItemsInRange(start, end []byte) bool {
+	if string(p.ph.lastItem) < string(start) {
+		return false
+	}
+	return len(end) == 0 || string(p.ph.firstItem) < string(end)
+}
+
+// MustClose closes the part files.
+func (p *part) MustClose() {
+	p.indexFile.MustClose()
+	p.itemsFile.MustClose()
+	p.lensFile.MustClose()
+}
+
 type indexBlock struct {
 	bhs []blockHeader
 
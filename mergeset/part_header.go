@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 	"os"
 	"strconv"
 	"strings"
@@ -19,8 +20,8 @@ type partHeader struct {
 func (ph *partHeader) CopyFrom(src *partHeader) {
 	ph.itemsCount = src.itemsCount
 	ph.blocksCount = src.blocksCount
-	ph.firstItem = append(ph.firstItem, src.firstItem...)
-	ph.lastItem = append(ph.lastItem, src.lastItem...)
+	ph.firstItem = append(ph.firstItem[:0], src.firstItem...)
+	ph.lastItem = append(ph.lastItem[:0], src.lastItem...)
 
 }
 
@@ -31,39 +32,81 @@ func (ph *partHeader) Reset() {
 	ph.lastItem = ph.lastItem[:0]
 }
 
+// Path returns the path of the part with ph inside tablePath.
+//
+// The part directory name has the <itemsCount>_<blocksCount>_<mergeIdx> format.
+func (ph *partHeader) Path(tablePath string, mergeIdx uint64) string {
+	return fmt.Sprintf("%s/%d_%d_%016X", tablePath, ph.itemsCount, ph.blocksCount, mergeIdx)
+}
 
+// ParseFromPath reads ph from the name and the metadata.json of the part at path.
 func (ph *partHeader) ParseFromPath(path string) error {
+	ph.Reset()
 
 	n := strings.LastIndexByte(path, '/')
 	fn := path[n+1:]
 	a := strings.Split(fn, "_")
+	if len(a) != 3 {
+		return fmt.Errorf("unexpected number of fields in the part name %q; got %d; want 3", fn, len(a))
+	}
 
 	itemsCount, err := strconv.ParseUint(a[0], 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot parse itemsCount from the part name %q: %w", fn, err)
 	}
-	ph.itemsCount = itemsCount
-
 	blocksCount, err := strconv.ParseUint(a[1], 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot parse blocksCount from the part name %q: %w", fn, err)
+	}
+	if _, err := strconv.ParseUint(a[2], 16, 64); err != nil {
+		return fmt.Errorf("cannot parse mergeIdx from the part name %q: %w", fn, err)
 	}
-	ph.blocksCount = blocksCount
 
-	metadata, err := os.ReadFile(path + "/metadata.json")
+	metadataPath := path + "/metadata.json"
+	metadata, err := os.ReadFile(metadataPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot read metadata: %w", err)
 	}
-
 	var phj partHeaderJson
-	if err = json.Unmarshal(metadata, &phj); err != nil {
-		fmt.Println(err)
-		return err
+	if err := json.Unmarshal(metadata, &phj); err != nil {
+		return fmt.Errorf("cannot parse %q: %w", metadataPath, err)
+	}
+	if phj.ItemsCount != itemsCount {
+		return fmt.Errorf("itemsCount in the part name %q doesn't match ItemsCount=%d in %q", fn, phj.ItemsCount, metadataPath)
+	}
+	if phj.BlocksCount != blocksCount {
+		return fmt.Errorf("blocksCount in the part name %q doesn't match BlocksCount=%d in %q", fn, phj.BlocksCount, metadataPath)
 	}
 
-	ph.firstItem = append(ph.firstItem, phj.FirstItem...)
-	ph.lastItem = append(ph.lastItem, phj.LastItem...)
+	ph.itemsCount = itemsCount
+	ph.blocksCount = blocksCount
+	ph.firstItem = append(ph.firstItem[:0], phj.FirstItem...)
+	ph.lastItem = append(ph.lastItem[:0], phj.LastItem...)
+	return nil
+}
 
+// WriteMetadata atomically writes ph to metadata.json in partPath and syncs it to disk.
+func (ph *partHeader) WriteMetadata(partPath string) error {
+	phj := &partHeaderJson{
+		ItemsCount:  ph.itemsCount,
+		BlocksCount: ph.blocksCount,
+		FirstItem:   ph.firstItem,
+		LastItem:    ph.lastItem,
+	}
+	metadata, err := json.MarshalIndent(phj, "", "\t")
+	if err != nil {
+		return fmt.Errorf("cannot marshal metadata: %w", err)
+	}
+	metadataPath := partPath + "/metadata.json"
+	tmpMetadataPath := metadataPath + ".tmp"
+	if err := os.WriteFile(tmpMetadataPath, metadata, 0644); err != nil {
+		return fmt.Errorf("cannot write metadata to %q: %w", tmpMetadataPath, err)
+	}
+	fs.MustSyncPath(tmpMetadataPath)
+	if err := os.Rename(tmpMetadataPath, metadataPath); err != nil {
+		return fmt.Errorf("cannot move %q to %q: %w", tmpMetadataPath, metadataPath, err)
+	}
+	fs.MustSyncPath(partPath)
 	return nil
 }
 
@@ -0,0 +1,80 @@
+package mergeset
+
+import (
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartHeaderParseFromPath(t *testing.T) {
+	tablePath := t.TempDir()
+	ph := &partHeader{
+		itemsCount:  123,
+		blocksCount: 4,
+		firstItem:   []byte("foo"),
+		lastItem:    []byte("foo\x00bar"),
+	}
+	partPath := ph.Path(tablePath, 0x1739E27FEE1F4E7A)
+	if name := filepath.Base(partPath); name != "123_4_1739E27FEE1F4E7A" {
+		t.Fatalf("unexpected part name; got %q; want %q", name, "123_4_1739E27FEE1F4E7A")
+	}
+	mustWritePartMetadata(t, ph, partPath)
+	if fs.IsPathExist(partPath + "/metadata.json.tmp") {
+		t.Fatalf("the temporary metadata file must be removed after writing metadata")
+	}
+
+	var ph2 partHeader
+	if err := ph2.ParseFromPath(partPath); err != nil {
+		t.Fatalf("cannot parse part header: %s", err)
+	}
+	if ph2.itemsCount != ph.itemsCount || ph2.blocksCount != ph.blocksCount ||
+		string(ph2.firstItem) != string(ph.firstItem) || string(ph2.lastItem) != string(ph.lastItem) {
+		t.Fatalf("unexpected part header; got %+v; want %+v", &ph2, ph)
+	}
+
+	// ph2 must be reset before parsing.
+	if err := ph2.ParseFromPath(partPath); err != nil {
+		t.Fatalf("cannot parse part header: %s", err)
+	}
+	if string(ph2.firstItem) != string(ph.firstItem) {
+		t.Fatalf("unexpected firstItem after the second parse; got %q; want %q", ph2.firstItem, ph.firstItem)
+	}
+}
+
+func TestPartHeaderParseFromPathFailure(t *testing.T) {
+	f := func(name, errSubstr string) {
+		t.Helper()
+		tablePath := t.TempDir()
+		ph := &partHeader{
+			itemsCount:  123,
+			blocksCount: 4,
+		}
+		partPath := tablePath + "/" + name
+		mustWritePartMetadata(t, ph, partPath)
+
+		var ph2 partHeader
+		err := ph2.ParseFromPath(partPath)
+		if err == nil || !strings.Contains(err.Error(), errSubstr) {
+			t.Fatalf("expecting an error containing %q for the part %q; got %v", errSubstr, name, err)
+		}
+	}
+
+	f("123_4", "unexpected number of fields")
+	f("123_4_0000000000000001_5", "unexpected number of fields")
+	f("foo_4_0000000000000001", "cannot parse itemsCount")
+	f("123_foo_0000000000000001", "cannot parse blocksCount")
+	f("123_4_foo", "cannot parse mergeIdx")
+	f("124_4_0000000000000001", "doesn't match ItemsCount")
+	f("123_5_0000000000000001", "doesn't match BlocksCount")
+}
+
+func mustWritePartMetadata(t *testing.T, ph *partHeader, partPath string) {
+	t.Helper()
+	if err := fs.MkdirAllFailIfExist(partPath); err != nil {
+		t.Fatalf("cannot create part directory: %s", err)
+	}
+	if err := ph.WriteMetadata(partPath); err != nil {
+		t.Fatalf("cannot write metadata: %s", err)
+	}
+}
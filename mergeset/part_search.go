@@ -21,25 +21,45 @@ type PartSearch struct {
 	sb          storageBlock
 	ib          *inMemoryBlock
 	ibItemIndex int
+
+	// end is the upper bound for the blocks read by ps. Empty end means no limit.
+	end []byte
+
+	// reverse is set after SeekLE. NextItem returns the items in descending order then.
+	reverse bool
+
+	// Indexes of the current metaindex row in p.mrs and the current block in bhs in reverse mode.
+	mrIdx int
+	bhIdx int
+
+	// The last error.
+	err error
 }
 
 func (ps *PartSearch) Init(p *part) {
 	ps.p = p
+	ps.end = nil
 }
 
+// Seek positions ps at the first item greater or equal to k. The item is obtained with NextItem.
 func (ps *PartSearch) Seek(k []byte) {
+	ps.Item = nil
+	ps.mrs = ps.p.mrs
+	ps.bhs = nil
+	ps.releaseBlock()
+	ps.ibItemIndex = 0
+	ps.err = nil
+	ps.reverse = false
+
 	if string(k) > string(ps.p.ph.lastItem) {
 		return
 	}
 
 	if string(k) <= string(ps.p.ph.firstItem) {
-		err := ps.nextBlock()
-		fmt.Println(err)
+		ps.err = ps.nextBlock()
 		return
 	}
 
-	ps.mrs = ps.p.mrs
-
 	n := sort.Search(len(ps.mrs), func(i int) bool {
 		return string(k) <= string(ps.mrs[i].firstItem)
 	})
@@ -50,6 +70,7 @@ func (ps *PartSearch) Seek(k []byte) {
 
 	ps.mrs = ps.mrs[n:]
 	if err := ps.nextBHS(); err != nil {
+		ps.err = err
 		return
 	}
 
@@ -63,6 +84,7 @@ func (ps *PartSearch) Seek(k []byte) {
 	ps.bhs = ps.bhs[n:]
 
 	if err := ps.nextBlock(); err != nil {
+		ps.err = err
 		return
 	}
 
@@ -86,9 +108,102 @@ func (ps *PartSearch) Seek(k []byte) {
 		return
 	}
 
-	if err := ps.nextBlock(); err != nil {
+	ps.err = ps.nextBlock()
+}
+
+// SeekLE positions ps at the last item smaller or equal to k.
+//
+// The item is obtained with NextItem, which returns the items in descending order after SeekLE.
+func (ps *PartSearch) SeekLE(k []byte) {
+	ps.Item = nil
+	ps.mrs = ps.p.mrs
+	ps.bhs = nil
+	ps.releaseBlock()
+	ps.ibItemIndex = 0
+	ps.err = nil
+	ps.reverse = true
+
+	if string(k) < string(ps.p.ph.firstItem) {
 		return
 	}
+
+	// Find the last index block and the last block with the first item smaller or equal to k.
+	ps.mrIdx = sort.Search(len(ps.mrs), func(i int) bool {
+		return string(k) < string(ps.mrs[i].firstItem)
+	}) - 1
+	if ps.mrIdx < 0 {
+		ps.mrIdx = 0
+	}
+	idxb, err := ps.readIndexBlock(&ps.mrs[ps.mrIdx])
+	if err != nil {
+		ps.err = err
+		return
+	}
+	ps.bhs = idxb.bhs
+	ps.bhIdx = sort.Search(len(ps.bhs), func(i int) bool {
+		return string(k) < string(ps.bhs[i].firstItem)
+	})
+	if err := ps.prevBlock(); err != nil {
+		ps.err = err
+		return
+	}
+
+	items := ps.ib.items
+	data := ps.ib.data
+	ps.ibItemIndex = sort.Search(len(items), func(i int) bool {
+		return string(k) < items[i].String(data)
+	}) - 1
+}
+
+// prevBlock reads the block preceding the current block in reverse mode.
+func (ps *PartSearch) prevBlock() error {
+	ps.bhIdx--
+	for ps.bhIdx < 0 {
+		ps.mrIdx--
+		if ps.mrIdx < 0 {
+			return io.EOF
+		}
+		idxb, err := ps.readIndexBlock(&ps.mrs[ps.mrIdx])
+		if err != nil {
+			return err
+		}
+		ps.bhs = idxb.bhs
+		ps.bhIdx = len(ps.bhs) - 1
+	}
+
+	ib, err := ps.getInmemoryBlock(&ps.bhs[ps.bhIdx])
+	if err != nil {
+		return err
+	}
+	ps.releaseBlock()
+	ps.ib = ib
+	ps.ibItemIndex = len(ib.items) - 1
+	return nil
+}
+
+// prevItem moves ps to the previous item in reverse mode.
+func (ps *PartSearch) prevItem() bool {
+	if ps.err != nil || ps.ib == nil {
+		// SeekLE didn't find the block with items <= k.
+		return false
+	}
+	if ps.ibItemIndex < 0 {
+		if err := ps.prevBlock(); err != nil {
+			ps.err = err
+			return false
+		}
+	}
+	ps.Item = ps.ib.items[ps.ibItemIndex].Bytes(ps.ib.data)
+	ps.ibItemIndex--
+	return true
+}
+
+// Error returns the last error occurred in Seek or NextItem.
+func (ps *PartSearch) Error() error {
+	if ps.err == io.EOF {
+		return nil
+	}
+	return ps.err
 }
 
 func binarySearchKey(data []byte, items []Item, key []byte) int {
@@ -121,6 +236,11 @@ func (ps *PartSearch) nextBHS() error {
 	}
 
 	mr := &ps.mrs[0]
+	if len(ps.end) > 0 && string(mr.firstItem) >= string(ps.end) {
+		// The remaining index blocks contain only items outside the range.
+		ps.mrs = nil
+		return io.EOF
+	}
 	ps.mrs = ps.mrs[1:]
 	idxb, err := ps.readIndexBlock(mr)
 	if err != nil {
@@ -140,17 +260,31 @@ func (ps *PartSearch) nextBlock() error {
 	}
 
 	bh := &ps.bhs[0]
+	if len(ps.end) > 0 && string(bh.firstItem) >= string(ps.end) {
+		// The remaining blocks contain only items outside the range.
+		ps.bhs = nil
+		ps.mrs = nil
+		return io.EOF
+	}
 	ps.bhs = ps.bhs[1:]
 
 	ib, err := ps.getInmemoryBlock(bh)
 	if err != nil {
 		return err
 	}
+	ps.releaseBlock()
 	ps.ib = ib
 	ps.ibItemIndex = 0
 	return nil
 }
 
+func (ps *PartSearch) releaseBlock() {
+	if ps.ib != nil {
+		pubInMemoryBlock(ps.ib)
+		ps.ib = nil
+	}
+}
+
 func (ps *PartSearch) getInmemoryBlock(bh *blockHeader) (*inMemoryBlock, error) {
 	ib, err := ps.readInmemoryBlock(bh)
 	if err != nil {
@@ -169,6 +303,7 @@ func (ps *PartSearch) readInmemoryBlock(bh *blockHeader) (*inMemoryBlock, error)
 
 	ib := getInMemoryBlock()
 	if err := ib.UnmarshalData(&ps.sb, bh.firstItem, bh.commonPrefix, bh.itemsCount, bh.marshalType); err != nil {
+		pubInMemoryBlock(ib)
 		return nil, fmt.Errorf("cannot unmarshal storage block with %d items: %w", bh.itemsCount, err)
 	}
 
@@ -176,7 +311,16 @@ func (ps *PartSearch) readInmemoryBlock(bh *blockHeader) (*inMemoryBlock, error)
 
 }
 
+// NextItem advances ps to the next item, or to the previous item after SeekLE. It returns false if there are no more items or on error,
+// which is returned by Error.
 func (ps *PartSearch) NextItem() bool {
+	if ps.reverse {
+		return ps.prevItem()
+	}
+	if ps.err != nil || ps.ib == nil {
+		// Seek didn't find the block with items >= k.
+		return false
+	}
 	items := ps.ib.items
 
 	if ps.ibItemIndex < len(items) {
@@ -186,6 +330,7 @@ func (ps *PartSearch) NextItem() bool {
 	}
 
 	if err := ps.nextBlock(); err != nil {
+		ps.err = err
 		return false
 	}
 	ps.Item = ps.ib.items[0].Bytes(ps.ib.data)
@@ -220,6 +365,10 @@ func (psh *partSearchHeap) Len() int {
 
 func (psh *partSearchHeap) Less(i, j int) bool {
 	x := *psh
+	if x[i].reverse {
+		// The heap is a max-heap in reverse mode.
+		return string(x[i].Item) > string(x[j].Item)
+	}
 	return string(x[i].Item) < string(x[j].Item)
 }
 
@@ -1,10 +1,15 @@
 package mergeset
 
 import (
+	"errors"
 	"fmt"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,11 +19,62 @@ const (
 	TableShards         = 2
 	MaxBlocksPerShard   = 16
 	DefaultPartsToMerge = 15
+	MaxInMemoryParts    = 30
+
+	DefaultFlushInterval = time.Second
+
+	// inMemoryPartsFlushInterval is the interval for merging in-memory parts into a file part,
+	// so the added items reach disk even if there are less than MaxInMemoryParts in-memory parts.
+	inMemoryPartsFlushInterval = 5 * time.Second
+
+	// MaxPartSize is the maximum size of the part produced by merges.
+	MaxPartSize = 400e9
+
+	// minMergeMultiplier is the minimum ratio of the merged part size to the size of the biggest source part.
+	//
+	// Higher values reduce write amplification at the cost of more unmerged parts.
+	minMergeMultiplier = 1.7
+
+	minMergeSleepTime = 10 * time.Millisecond
+	maxMergeSleepTime = time.Second
 )
 
+// Options configures Table. Zero fields are set to their defaults.
+type Options struct {
+	// FlushInterval is the interval after which the added items become visible for search.
+	FlushInterval time.Duration
+
+	// MergeWorkers is the number of background workers merging parts. GOMAXPROCS workers are used by default.
+	MergeWorkers int
+
+	// QuarantineBrokenParts moves the parts, which cannot be opened, to the broken directory
+	// instead of failing OpenTable.
+	QuarantineBrokenParts bool
+
+	// PrepareBlock is called for every block of items created when merging the items.
+	// It may drop items from the block, e.g. the items superseded by other items.
+	PrepareBlock PrepareBlockCallback
+
+	// inMemoryPartsFlushInterval overrides inMemoryPartsFlushInterval in tests.
+	inMemoryPartsFlushInterval time.Duration
+}
+
+func (opts *Options) normalize() {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.inMemoryPartsFlushInterval <= 0 {
+		opts.inMemoryPartsFlushInterval = inMemoryPartsFlushInterval
+	}
+	if opts.MergeWorkers <= 0 {
+		opts.MergeWorkers = runtime.GOMAXPROCS(-1)
+	}
+}
+
 type Table struct {
 	path     string
 	mergeIdx uint64
+	opts     Options
 
 	parts     []*partWrapper
 	partsLock sync.RWMutex
@@ -26,53 +82,148 @@ type Table struct {
 	rawItems     rawItemShards
 	prepareBlock PrepareBlockCallback
 	itemsMerged  uint64
+
+	// needMergeCh wakes up the merge workers when new parts are added.
+	needMergeCh chan struct{}
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
 }
 
-func OpenTable(path string) *Table {
+// OpenTable opens the table at path. The table is created if it doesn't exist.
+//
+// MustClose must be called when the table is no longer needed.
+func OpenTable(path string, opts Options) (*Table, error) {
+	tb, err := openTable(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	tb.startPartMergers()
+	tb.startInMemoryPartsFlusher()
+	return tb, nil
+}
 
+// openTable opens the table at path without starting the background merge workers
+// and the in-memory parts flusher.
+func openTable(path string, opts Options) (*Table, error) {
 	path = filepath.Clean(path)
 	if err := fs.MkdirAllIfNotExist(path); err != nil {
-		return nil
+		return nil, fmt.Errorf("cannot create directory %q: %w", path, err)
 	}
 
-	pws, err := openParts(path)
+	if err := runTransactions(path); err != nil {
+		return nil, fmt.Errorf("cannot run unfinished transactions for table %q: %w", path, err)
+	}
+	if err := removeTmpParts(path); err != nil {
+		return nil, fmt.Errorf("cannot remove temporary parts for table %q: %w", path, err)
+	}
+
+	pws, err := openParts(path, opts.QuarantineBrokenParts)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("cannot open parts for table %q: %w", path, err)
 	}
 
+	opts.normalize()
 	t := &Table{
-		path:     path,
-		parts:    pws,
-		mergeIdx: uint64(time.Now().UnixNano()),
+		path:         path,
+		parts:        pws,
+		mergeIdx:     uint64(time.Now().UnixNano()),
+		opts:         opts,
+		prepareBlock: opts.PrepareBlock,
+		needMergeCh:  make(chan struct{}, 1),
+		stopCh:       make(chan struct{}),
 	}
 	t.rawItems.init()
-	return t
+	t.startRawItemsFlusher()
+	return t, nil
 }
 
-func openParts(path string) ([]*partWrapper, error) {
+// runTransactions finishes the transactions left in the txn directory after an unclean shutdown.
+func runTransactions(path string) error {
+	txnDir := path + "/txn"
+	if err := fs.MkdirAllIfNotExist(txnDir); err != nil {
+		return fmt.Errorf("cannot create directory %q: %w", txnDir, err)
+	}
+	// Transactions, which weren't fully written, are incomplete merges. Drop them.
+	tmpTxnPaths, err := filepath.Glob(txnDir + "/*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot find incomplete transactions in %q: %w", txnDir, err)
+	}
+	for _, tmpTxnPath := range tmpTxnPaths {
+		if err := os.Remove(tmpTxnPath); err != nil {
+			return fmt.Errorf("cannot remove incomplete transaction %q: %w", tmpTxnPath, err)
+		}
+	}
 
-	var pws []*partWrapper
-	d, err := os.Open(path)
+	des, err := os.ReadDir(txnDir)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("cannot read directory %q: %w", txnDir, err)
 	}
+	// The transactions must be applied in the order they were created.
+	// os.ReadDir returns them sorted by name, i.e. by mergeIdx.
+	for _, de := range des {
+		txnPath := txnDir + "/" + de.Name()
+		if err := runTransaction(path, txnPath); err != nil {
+			return fmt.Errorf("cannot run transaction %q: %w", txnPath, err)
+		}
+	}
+	return nil
+}
 
-	fis, err := d.Readdir(-1)
+// removeTmpParts removes the parts of the merges, which were interrupted before their transactions were created.
+func removeTmpParts(path string) error {
+	tmpDir := path + "/tmp"
+	if err := fs.MkdirAllIfNotExist(tmpDir); err != nil {
+		return fmt.Errorf("cannot create directory %q: %w", tmpDir, err)
+	}
+	des, err := os.ReadDir(tmpDir)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("cannot read directory %q: %w", tmpDir, err)
 	}
+	for _, de := range des {
+		fs.MustRemoveDirAtomic(tmpDir + "/" + de.Name())
+	}
+	fs.MustSyncPath(tmpDir)
+	return nil
+}
 
-	for _, fi := range fis {
+// openParts opens the file parts of the table at path.
+//
+// Unreadable parts are moved to the broken directory if quarantineBrokenParts is set.
+func openParts(path string, quarantineBrokenParts bool) ([]*partWrapper, error) {
+	des, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory: %w", err)
+	}
+
+	var pws []*partWrapper
+	for _, de := range des {
+		fi, err := de.Info()
+		if err != nil {
+			mustCloseParts(pws)
+			return nil, fmt.Errorf("cannot stat %q: %w", de.Name(), err)
+		}
 		if !fs.IsDirOrSymlink(fi) {
 			continue
 		}
-		if fi.Name() == "tmp" || fi.Name() == "txn" {
+		switch fi.Name() {
+		case "tmp", "txn", "broken":
 			continue
 		}
 
-		p, err := openFilePart(path + "/" + fi.Name())
+		partPath := path + "/" + fi.Name()
+		p, err := openFilePart(partPath)
 		if err != nil {
-			return nil, err
+			if !quarantineBrokenParts {
+				mustCloseParts(pws)
+				return nil, err
+			}
+			if err := quarantinePart(path, partPath); err != nil {
+				mustCloseParts(pws)
+				return nil, err
+			}
+			logger.Errorf("moved broken part %q to the broken directory: %s", partPath, err)
+			continue
 		}
 
 		pw := partWrapper{
@@ -80,16 +231,119 @@ func openParts(path string) ([]*partWrapper, error) {
 			refCount: 1,
 		}
 		pws = append(pws, &pw)
+	}
+	return pws, nil
+}
 
+// quarantinePart moves the part at partPath to the broken directory of the table at tablePath.
+func quarantinePart(tablePath, partPath string) error {
+	brokenDir := tablePath + "/broken"
+	if err := fs.MkdirAllIfNotExist(brokenDir); err != nil {
+		return fmt.Errorf("cannot create directory %q: %w", brokenDir, err)
 	}
+	dstPath := brokenDir + "/" + filepath.Base(partPath)
+	if err := os.Rename(partPath, dstPath); err != nil {
+		return fmt.Errorf("cannot move broken part %q to %q: %w", partPath, dstPath, err)
+	}
+	fs.MustSyncPath(brokenDir)
+	fs.MustSyncPath(tablePath)
+	return nil
+}
 
-	return pws, nil
+func mustCloseParts(pws []*partWrapper) {
+	for _, pw := range pws {
+		pw.p.MustClose()
+	}
 }
 
 func (tb *Table) AddItems(items [][]byte) {
 	tb.rawItems.addItems(tb, items)
 }
 
+// MustClose stops the background workers, writes all the added items to disk and closes the table.
+//
+// It mustn't be called concurrently with AddItems. The parts used by the in-flight searches
+// are closed when the searches are closed.
+func (tb *Table) MustClose() {
+	close(tb.stopCh)
+	tb.wg.Wait()
+
+	tb.flushRawItems(true)
+	tb.mergeInMemoryParts(0, nil)
+
+	tb.partsLock.Lock()
+	pws := tb.parts
+	tb.parts = nil
+	tb.partsLock.Unlock()
+
+	for _, pw := range pws {
+		pw.decRef()
+	}
+}
+
+// Flush makes all the added items visible for search without waiting for FlushInterval.
+func (tb *Table) Flush() {
+	tb.flushRawItems(true)
+}
+
+// DebugFlush makes all the added items visible for search.
+//
+// It is intended for tests.
+func (tb *Table) DebugFlush() {
+	tb.flushRawItems(true)
+}
+
+func (tb *Table) startRawItemsFlusher() {
+	tb.wg.Add(1)
+	go func() {
+		defer tb.wg.Done()
+		tb.rawItemsFlusher()
+	}()
+}
+
+func (tb *Table) rawItemsFlusher() {
+	ticker := time.NewTicker(tb.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tb.stopCh:
+			return
+		case <-ticker.C:
+			tb.flushRawItems(false)
+		}
+	}
+}
+
+func (tb *Table) startInMemoryPartsFlusher() {
+	tb.wg.Add(1)
+	go func() {
+		defer tb.wg.Done()
+		tb.inMemoryPartsFlusher()
+	}()
+}
+
+// inMemoryPartsFlusher merges all the in-memory parts into a file part every inMemoryPartsFlushInterval.
+//
+// The items, which weren't merged into file parts yet, are lost on unclean shutdown.
+func (tb *Table) inMemoryPartsFlusher() {
+	ticker := time.NewTicker(tb.opts.inMemoryPartsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tb.stopCh:
+			return
+		case <-ticker.C:
+			tb.mergeInMemoryParts(0, tb.stopCh)
+		}
+	}
+}
+
+// flushRawItems converts the raw items, which weren't flushed during FlushInterval, into in-memory parts.
+// All the raw items are flushed if isFinal is set.
+func (tb *Table) flushRawItems(isFinal bool) {
+	tb.rawItems.flush(tb, isFinal)
+}
+
 func (tb *Table) getParts(dst []*partWrapper) []*partWrapper {
 
 	tb.partsLock.Lock()
@@ -106,11 +360,11 @@ func (tb *Table) mergeRawItemBlocks(ibs []*inMemoryBlock, final bool) {
 		return
 	}
 
-
 	var wg sync.WaitGroup
+	var pwsLock sync.Mutex
 	pws := make([]*partWrapper, 0, (len(ibs)+DefaultPartsToMerge-1)/DefaultPartsToMerge)
 
-	for i := 0; i < len(ibs); i++ {
+	for len(ibs) > 0 {
 		n := DefaultPartsToMerge
 		if n > len(ibs) {
 			n = len(ibs)
@@ -123,18 +377,239 @@ func (tb *Table) mergeRawItemBlocks(ibs []*inMemoryBlock, final bool) {
 			if pw == nil {
 				return
 			}
+			pwsLock.Lock()
 			pws = append(pws, pw)
-
+			pwsLock.Unlock()
 		}(ibs[:n])
 		ibs = ibs[n:]
 	}
 
 	wg.Wait()
 
-	if len(pws) > 0 {
-		tb.mergeParts()
+	// Register the in-memory parts, so their items become searchable.
+	// They are merged by the merge workers.
+	tb.partsLock.Lock()
+	tb.parts = append(tb.parts, pws...)
+	tb.partsLock.Unlock()
+
+	select {
+	case tb.needMergeCh <- struct{}{}:
+	default:
+	}
+}
+
+// mergeInMemoryParts merges the in-memory parts into a file part if there are more than maxParts of them.
+//
+// The merge is cancelled when stopCh is closed. The in-memory parts are left in tb.parts in this case.
+func (tb *Table) mergeInMemoryParts(maxParts int, stopCh <-chan struct{}) {
+	tb.partsLock.Lock()
+	pws := getInMemoryPartsToMerge(tb.parts, maxParts)
+	tb.partsLock.Unlock()
+
+	err := tb.mergePartsInMerge(pws, stopCh)
+	if err != nil && err != errForciblyStopped {
+		logger.Panicf("FATAL: cannot merge in-memory parts to %q: %s", tb.path, err)
+	}
+}
+
+// mergePartsInMerge merges pws, which are marked with isInMerge.
+//
+// pws are unmarked if the merge fails.
+func (tb *Table) mergePartsInMerge(pws []*partWrapper, stopCh <-chan struct{}) error {
+	err := tb.mergeParts(pws, stopCh)
+	if err != nil {
+		tb.partsLock.Lock()
+		for _, pw := range pws {
+			pw.isInMerge = false
+		}
+		tb.partsLock.Unlock()
+	}
+	return err
+}
+
+func (tb *Table) startPartMergers() {
+	for i := 0; i < tb.opts.MergeWorkers; i++ {
+		tb.wg.Add(1)
+		go func() {
+			defer tb.wg.Done()
+			tb.partMerger()
+		}()
+	}
+}
+
+var errNothingToMerge = errors.New("nothing to merge")
+
+// partMerger merges the existing parts until tb.stopCh is closed.
+//
+// It backs off up to maxMergeSleepTime while there is nothing to merge.
+func (tb *Table) partMerger() {
+	sleepTime := minMergeSleepTime
+	t := time.NewTimer(sleepTime)
+	defer t.Stop()
+	for {
+		err := tb.mergeExistingParts()
+		if err == nil {
+			// Try merging additional parts.
+			sleepTime = minMergeSleepTime
+			continue
+		}
+		if err == errForciblyStopped {
+			return
+		}
+		if err != errNothingToMerge {
+			logger.Panicf("FATAL: unrecoverable error when merging parts in %q: %s", tb.path, err)
+		}
+
+		sleepTime *= 2
+		if sleepTime > maxMergeSleepTime {
+			sleepTime = maxMergeSleepTime
+		}
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(sleepTime)
+		select {
+		case <-tb.stopCh:
+			return
+		case <-tb.needMergeCh:
+			sleepTime = minMergeSleepTime
+		case <-t.C:
+		}
+	}
+}
+
+func (tb *Table) mergeExistingParts() error {
+	maxOutBytes := tb.maxOutPartBytes()
+
+	tb.partsLock.Lock()
+	pws := getInMemoryPartsToMerge(tb.parts, MaxInMemoryParts)
+	if len(pws) == 0 {
+		pws = getPartsToMerge(tb.parts, maxOutBytes)
+	}
+	tb.partsLock.Unlock()
+
+	if len(pws) == 0 {
+		return errNothingToMerge
+	}
+	return tb.mergePartsInMerge(pws, tb.stopCh)
+}
+
+// maxOutPartBytes returns the maximum size of the part a single merge worker may create.
+func (tb *Table) maxOutPartBytes() uint64 {
+	n := fs.MustGetFreeSpace(tb.path) / uint64(tb.opts.MergeWorkers)
+	if n > MaxPartSize {
+		n = MaxPartSize
+	}
+	return n
+}
+
+// getInMemoryPartsToMerge returns all the in-memory parts from pws, which aren't in merge,
+// if there are more than maxParts of them. It marks the returned parts with isInMerge.
+//
+// The caller must hold Table.partsLock.
+func getInMemoryPartsToMerge(pws []*partWrapper, maxParts int) []*partWrapper {
+	var dst []*partWrapper
+	for _, pw := range pws {
+		if pw.mp != nil && !pw.isInMerge {
+			dst = append(dst, pw)
+		}
+	}
+	if len(dst) <= maxParts {
+		return nil
+	}
+	for _, pw := range dst {
+		pw.isInMerge = true
+	}
+	return dst
+}
+
+// getPartsToMerge returns the optimal parts to merge from pws, which aren't in merge.
+// It marks the returned parts with isInMerge.
+//
+// The caller must hold Table.partsLock.
+func getPartsToMerge(pws []*partWrapper, maxOutBytes uint64) []*partWrapper {
+	src := make([]*partWrapper, 0, len(pws))
+	for _, pw := range pws {
+		if !pw.isInMerge {
+			src = append(src, pw)
+		}
+	}
+	dst := appendPartsToMerge(nil, src, DefaultPartsToMerge, maxOutBytes)
+	for _, pw := range dst {
+		pw.isInMerge = true
+	}
+	return dst
+}
+
+// appendPartsToMerge appends to dst up to maxPartsToMerge parts from src with similar sizes,
+// which give the lowest write amplification when merged into a part not exceeding maxOutBytes.
+//
+// Nothing is appended if the merge isn't worth it.
+func appendPartsToMerge(dst, src []*partWrapper, maxPartsToMerge int, maxOutBytes uint64) []*partWrapper {
+	if len(src) < 2 {
+		return dst
 	}
 
+	// Filter out too big parts. This reduces n for the O(n^2) search below.
+	maxInPartBytes := uint64(float64(maxOutBytes) / minMergeMultiplier)
+	tmp := make([]*partWrapper, 0, len(src))
+	for _, pw := range src {
+		if pw.p.size <= maxInPartBytes {
+			tmp = append(tmp, pw)
+		}
+	}
+	src = tmp
+	sort.Slice(src, func(i, j int) bool {
+		return src[i].p.size < src[j].p.size
+	})
+
+	maxSrcParts := maxPartsToMerge
+	if maxSrcParts > len(src) {
+		maxSrcParts = len(src)
+	}
+	minSrcParts := (maxSrcParts + 1) / 2
+	if minSrcParts < 2 {
+		minSrcParts = 2
+	}
+
+	var pws []*partWrapper
+	maxM := float64(0)
+	for i := minSrcParts; i <= maxSrcParts; i++ {
+		for j := 0; j <= len(src)-i; j++ {
+			a := src[j : j+i]
+			if a[0].p.size*uint64(len(a)) < a[len(a)-1].p.size {
+				// Do not merge parts with too big difference in size.
+				continue
+			}
+			outBytes := uint64(0)
+			for _, pw := range a {
+				outBytes += pw.p.size
+			}
+			if outBytes > maxOutBytes {
+				// The next windows contain bigger parts.
+				break
+			}
+			m := float64(outBytes) / float64(a[len(a)-1].p.size)
+			if m < maxM {
+				continue
+			}
+			maxM = m
+			pws = a
+		}
+	}
+
+	minM := float64(maxPartsToMerge) / 2
+	if minM < minMergeMultiplier {
+		minM = minMergeMultiplier
+	}
+	if maxM < minM {
+		// Merging parts with too small m results in high write amplification.
+		return dst
+	}
+	return append(dst, pws...)
 }
 
 func (tb *Table) mergeInMemoryBlocks(ibs []*inMemoryBlock) *partWrapper {
@@ -147,7 +622,6 @@ func (tb *Table) mergeInMemoryBlocks(ibs []*inMemoryBlock) *partWrapper {
 		bsr := getBlockStreamReader()
 		bsr.InitFromBlockStreamReader(ib)
 		pubInMemoryBlock(ib)
-		fmt.Println(bsr.Block.items)
 		bsrs = append(bsrs, bsr)
 	}
 
@@ -158,6 +632,7 @@ func (tb *Table) mergeInMemoryBlocks(ibs []*inMemoryBlock) *partWrapper {
 	if len(bsrs) == 1 {
 		mp := &inMemoryPart{}
 		mp.Init(&bsrs[0].Block)
+		putBlockStreamReader(bsrs[0])
 		p := mp.NewPart()
 
 		return &partWrapper{
@@ -171,9 +646,12 @@ func (tb *Table) mergeInMemoryBlocks(ibs []*inMemoryBlock) *partWrapper {
 	mpDst := &inMemoryPart{}
 	bsw.InitFromInMemoryPart(mpDst)
 
+	err := mergeBlockStreams(&mpDst.ph, bsw, bsrs, tb.prepareBlock, nil, &tb.itemsMerged)
+	if err != nil {
+		logger.Panicf("FATAL: cannot merge in-memory blocks: %s", err)
+	}
 
-	mergeBlockStreams(&mpDst.ph, bsw, bsrs, tb.prepareBlock, nil, &tb.itemsMerged)
-
+	bsw.MustClose()
 	putBlockStreamWriter(bsw)
 	for _, bsr := range bsrs {
 		putBlockStreamReader(bsr)
@@ -188,20 +666,252 @@ func (tb *Table) mergeInMemoryBlocks(ibs []*inMemoryBlock) *partWrapper {
 	}
 }
 
-func (tb *Table) mergeParts() error{
+// mergeParts merges pws into a new file part and replaces pws with it in tb.parts.
+//
+// The merge is interrupted with errForciblyStopped when stopCh is closed.
+func (tb *Table) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) error {
+	if len(pws) == 0 {
+		return nil
+	}
+
+	bsrs := make([]*blockStreamReader, 0, len(pws))
+	defer func() {
+		for _, bsr := range bsrs {
+			bsr.MustClose()
+			putBlockStreamReader(bsr)
+		}
+	}()
+	for _, pw := range pws {
+		bsr := getBlockStreamReader()
+		if pw.mp != nil {
+			bsr.InitFromInMemoryPart(pw.mp)
+		} else if err := bsr.InitFromFilePart(pw.p.path); err != nil {
+			putBlockStreamReader(bsr)
+			return fmt.Errorf("cannot open source part for merging: %w", err)
+		}
+		bsrs = append(bsrs, bsr)
+	}
 
 	mergeIdx := tb.nextMergeIdx()
 	tmpPartPath := fmt.Sprintf("%s/tmp/%016X", tb.path, mergeIdx)
 	bsw := getBlockStreamWriter()
 	compressLevel := -5
 	if err := bsw.InitFromFilePart(tmpPartPath, false, compressLevel); err != nil {
+		putBlockStreamWriter(bsw)
 		return fmt.Errorf("cannot create destination part %q: %w", tmpPartPath, err)
 	}
 
+	var ph partHeader
+	err := mergeBlockStreams(&ph, bsw, bsrs, tb.prepareBlock, stopCh, &tb.itemsMerged)
+	bsw.MustClose()
+	putBlockStreamWriter(bsw)
+	if err != nil {
+		fs.MustRemoveDirAtomic(tmpPartPath)
+		if err == errForciblyStopped {
+			return err
+		}
+		return fmt.Errorf("cannot merge %d parts to %q: %w", len(pws), tmpPartPath, err)
+	}
+
+	dstPartPath := ""
+	if ph.itemsCount > 0 {
+		if err := ph.WriteMetadata(tmpPartPath); err != nil {
+			fs.MustRemoveDirAtomic(tmpPartPath)
+			return fmt.Errorf("cannot write metadata for the merged part: %w", err)
+		}
+		dstPartPath = ph.Path(tb.path, mergeIdx)
+	}
+
+	// Record the transaction, so the source parts are replaced with the merged part
+	// on the next OpenTable if the process crashes in the middle of the swap.
+	txnPath := fmt.Sprintf("%s/txn/%016X", tb.path, mergeIdx)
+	if err := writeTransaction(txnPath, pws, tmpPartPath, dstPartPath); err != nil {
+		fs.MustRemoveDirAtomic(tmpPartPath)
+		return fmt.Errorf("cannot create transaction %q: %w", txnPath, err)
+	}
+
+	if dstPartPath != "" {
+		if err := os.Rename(tmpPartPath, dstPartPath); err != nil {
+			return fmt.Errorf("cannot move %q to %q: %w", tmpPartPath, dstPartPath, err)
+		}
+	} else {
+		fs.MustRemoveDirAtomic(tmpPartPath)
+	}
+	fs.MustSyncPath(tb.path)
+
+	var newPW *partWrapper
+	if dstPartPath != "" {
+		p, err := openFilePart(dstPartPath)
+		if err != nil {
+			// The source parts stay in the table. The transaction replaces them
+			// with the merged part on the next OpenTable.
+			return fmt.Errorf("cannot open the merged part %q: %w", dstPartPath, err)
+		}
+		newPW = &partWrapper{
+			p:        p,
+			refCount: 1,
+		}
+	}
+	tb.swapParts(pws, newPW)
 
+	// The source file parts are removed when the in-flight searches release them.
+	// The transaction is kept until then, so the source parts are removed
+	// on the next OpenTable if the process crashes before that.
+	txn := &pendingTxn{
+		path: txnPath,
+	}
+	for _, pw := range pws {
+		if pw.mp == nil {
+			pw.txn = txn
+			txn.refCount++
+			atomic.StoreUint32(&pw.mustBeDeleted, 1)
+		}
+	}
+	if txn.refCount == 0 {
+		txn.mustRemove()
+	}
+	for _, pw := range pws {
+		pw.decRef()
+	}
 	return nil
 }
 
+// pendingTxn is the transaction, which is waiting for the removal of its source parts.
+type pendingTxn struct {
+	path     string
+	refCount int32
+}
+
+func (txn *pendingTxn) decRef() {
+	if atomic.AddInt32(&txn.refCount, -1) == 0 {
+		txn.mustRemove()
+	}
+}
+
+func (txn *pendingTxn) mustRemove() {
+	if err := os.Remove(txn.path); err != nil {
+		logger.Panicf("FATAL: cannot remove transaction file %q: %s", txn.path, err)
+	}
+	fs.MustSyncPath(filepath.Dir(txn.path))
+}
+
+// writeTransaction atomically writes to txnPath the transaction for replacing the file parts from pws
+// with the part at tmpPartPath, which must be moved to dstPartPath.
+//
+// dstPartPath is empty if the merged part has no items. The paths are stored relative to the table path.
+func writeTransaction(txnPath string, pws []*partWrapper, tmpPartPath, dstPartPath string) error {
+	var data []byte
+	for _, pw := range pws {
+		if pw.mp == nil {
+			data = append(data, filepath.Base(pw.p.path)...)
+			data = append(data, '\n')
+		}
+	}
+	dstPartName := ""
+	if dstPartPath != "" {
+		dstPartName = filepath.Base(dstPartPath)
+	}
+	data = append(data, fmt.Sprintf("tmp/%s -> %s\n", filepath.Base(tmpPartPath), dstPartName)...)
+
+	txnDir := filepath.Dir(txnPath)
+	if err := fs.MkdirAllIfNotExist(txnDir); err != nil {
+		return fmt.Errorf("cannot create directory %q: %w", txnDir, err)
+	}
+	tmpTxnPath := txnPath + ".tmp"
+	if err := os.WriteFile(tmpTxnPath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write %q: %w", tmpTxnPath, err)
+	}
+	fs.MustSyncPath(tmpTxnPath)
+	if err := os.Rename(tmpTxnPath, txnPath); err != nil {
+		return fmt.Errorf("cannot move %q to %q: %w", tmpTxnPath, txnPath, err)
+	}
+	fs.MustSyncPath(txnDir)
+	return nil
+}
+
+// runTransaction removes the source parts recorded in txnPath and moves the merged part to its final place.
+//
+// It may be called multiple times for the same transaction, e.g. after a crash in the middle of the previous call.
+func runTransaction(tablePath, txnPath string) error {
+	data, err := os.ReadFile(txnPath)
+	if err != nil {
+		return fmt.Errorf("cannot read transaction file: %w", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	srcPartNames := lines[:len(lines)-1]
+	mvLine := lines[len(lines)-1]
+	n := strings.Index(mvLine, " -> ")
+	if n < 0 {
+		return fmt.Errorf("cannot find \" -> \" in the last line of the transaction file: %q", mvLine)
+	}
+	tmpPartName := mvLine[:n]
+	dstPartName := mvLine[n+len(" -> "):]
+	if !strings.HasPrefix(tmpPartName, "tmp/") || !isValidPartName(tmpPartName[len("tmp/"):]) {
+		return fmt.Errorf("invalid temporary part name %q", tmpPartName)
+	}
+	if dstPartName != "" && !isValidPartName(dstPartName) {
+		return fmt.Errorf("invalid destination part name %q", dstPartName)
+	}
+
+	// Remove the source parts. Some of them may be already removed by the previous run.
+	for _, name := range srcPartNames {
+		if !isValidPartName(name) {
+			return fmt.Errorf("invalid source part name %q", name)
+		}
+		srcPartPath := tablePath + "/" + name
+		if fs.IsPathExist(srcPartPath) {
+			fs.MustRemoveDirAtomic(srcPartPath)
+		}
+	}
+
+	// Move the merged part to its final place. It may be already moved by the previous run.
+	tmpPartPath := tablePath + "/" + tmpPartName
+	if _, err := os.Stat(tmpPartPath); err == nil {
+		if dstPartName == "" {
+			fs.MustRemoveDirAtomic(tmpPartPath)
+		} else {
+			dstPartPath := tablePath + "/" + dstPartName
+			if err := os.Rename(tmpPartPath, dstPartPath); err != nil {
+				return fmt.Errorf("cannot move %q to %q: %w", tmpPartPath, dstPartPath, err)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot stat %q: %w", tmpPartPath, err)
+	}
+	fs.MustSyncPath(tablePath)
+
+	if err := os.Remove(txnPath); err != nil {
+		return fmt.Errorf("cannot remove transaction file: %w", err)
+	}
+	return nil
+}
+
+// isValidPartName returns true if name is a plain directory name inside the table directory.
+func isValidPartName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsRune(name, '/')
+}
+
+// swapParts removes src from tb.parts and adds dst to them if it isn't nil.
+func (tb *Table) swapParts(src []*partWrapper, dst *partWrapper) {
+	m := make(map[*partWrapper]struct{}, len(src))
+	for _, pw := range src {
+		m[pw] = struct{}{}
+	}
+
+	tb.partsLock.Lock()
+	parts := make([]*partWrapper, 0, len(tb.parts)+1)
+	for _, pw := range tb.parts {
+		if _, ok := m[pw]; !ok {
+			parts = append(parts, pw)
+		}
+	}
+	if dst != nil {
+		parts = append(parts, dst)
+	}
+	tb.parts = parts
+	tb.partsLock.Unlock()
+}
+
 func (tb *Table) nextMergeIdx() uint64 {
 	return atomic.AddUint64(&tb.mergeIdx, 1)
 }
@@ -210,12 +920,41 @@ type partWrapper struct {
 	p        *part
 	mp       *inMemoryPart
 	refCount uint64
+
+	// mustBeDeleted is set when the part is merged away. The part directory is removed
+	// when the last reference to the part is released.
+	mustBeDeleted uint32
+
+	// txn is the transaction, which merged the part away.
+	txn *pendingTxn
+
+	// isInMerge is set when the part is being merged. It is protected by Table.partsLock.
+	isInMerge bool
 }
 
 func (pw *partWrapper) inxRef() {
 	atomic.AddUint64(&pw.refCount, 1)
 }
 
+// decRef releases the reference to the part. The part is closed when the last reference is released.
+func (pw *partWrapper) decRef() {
+	n := atomic.AddUint64(&pw.refCount, ^uint64(0))
+	if int64(n) < 0 {
+		logger.Panicf("BUG: pw.refCount must be bigger than 0; got %d", int64(n))
+	}
+	if n > 0 {
+		return
+	}
+
+	pw.p.MustClose()
+	if atomic.LoadUint32(&pw.mustBeDeleted) == 0 {
+		return
+	}
+	fs.MustRemoveDirAtomic(pw.p.path)
+	fs.MustSyncPath(filepath.Dir(pw.p.path))
+	pw.txn.decRef()
+}
+
 type rawItemShards struct {
 	shardIndex uint32
 	shards     []rawItemShard
@@ -234,17 +973,22 @@ func (riss *rawItemShards) addItems(tb *Table, items [][]byte) {
 
 }
 
+func (riss *rawItemShards) flush(tb *Table, isFinal bool) {
+	var ibs []*inMemoryBlock
+	for i := range riss.shards {
+		ibs = riss.shards[i].appendBlocksToFlush(ibs, tb, isFinal)
+	}
+	tb.mergeRawItemBlocks(ibs, isFinal)
+}
+
 type rawItemShard struct {
 	rawItemShardNopad
 }
 
 func (ris *rawItemShard) addItems(tb *Table, items [][]byte) {
-
-	ris.mu.Lock()
-	defer ris.mu.Unlock()
-
 	var blocksToFlush []*inMemoryBlock
 
+	ris.mu.Lock()
 	ibs := ris.ibs
 	if len(ibs) == 0 {
 		ib := getInMemoryBlock()
@@ -256,21 +1000,49 @@ func (ris *rawItemShard) addItems(tb *Table, items [][]byte) {
 	for _, item := range items {
 		if !ib.Add(item) {
 			ib = getInMemoryBlock()
-			ib.Add(item)
+			if !ib.Add(item) {
+				pubInMemoryBlock(ib)
+				ris.mu.Unlock()
+				logger.Panicf("BUG: cannot add too big item with len=%d; it mustn't exceed %d bytes", len(item), MaxInMemoryBlockSize)
+			}
 			ibs = append(ibs, ib)
 			ris.ibs = ibs
 		}
 	}
-	fmt.Println(len(ib.data))
 
 	if len(ibs) >= MaxBlocksPerShard {
 		blocksToFlush = append(blocksToFlush, ibs...)
+		for i := range ibs {
+			ibs[i] = nil
+		}
+		ris.ibs = ibs[:0]
+		atomic.StoreUint64(&ris.lastFlashTime, uint64(time.Now().UnixNano()))
 	}
+	ris.mu.Unlock()
 
-	//blocksToFlush = append(blocksToFlush, ibs...)
 	tb.mergeRawItemBlocks(blocksToFlush, false)
 }
 
+func (ris *rawItemShard) appendBlocksToFlush(dst []*inMemoryBlock, tb *Table, isFinal bool) []*inMemoryBlock {
+	currentTime := uint64(time.Now().UnixNano())
+	lastFlushTime := atomic.LoadUint64(&ris.lastFlashTime)
+	if !isFinal && currentTime < lastFlushTime+uint64(tb.opts.FlushInterval) {
+		// Fast path - the shard was recently flushed.
+		return dst
+	}
+
+	ris.mu.Lock()
+	ibs := ris.ibs
+	dst = append(dst, ibs...)
+	for i := range ibs {
+		ibs[i] = nil
+	}
+	ris.ibs = ibs[:0]
+	atomic.StoreUint64(&ris.lastFlashTime, currentTime)
+	ris.mu.Unlock()
+	return dst
+}
+
 type rawItemShardNopad struct {
 	lastFlashTime uint64
 	mu            sync.RWMutex
@@ -1,19 +1,49 @@
 package mergeset
 
-import "container/heap"
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+)
 
+// TableSearch searches for items in Table.
+//
+// TableSearch isn't safe for concurrent use.
 type TableSearch struct {
-	tb           *Table
-	Item         []byte
+	tb *Table
+
+	// Item is the current item. It is valid until the next call to Seek or NextItem.
+	Item []byte
+
 	pws          []*partWrapper
 	psPool       []PartSearch
 	psHeap       partSearchHeap
 	nextItemNoop bool
+
+	// prefix limits NextItem to the items with the prefix after FirstItemWithPrefix.
+	prefix []byte
+
+	// end limits NextItem to the items smaller than end after SeekRange. Empty end means no limit.
+	end []byte
+
+	// The last error.
+	err error
+
+	needClosing bool
 }
 
+// Init initializes ts for searching in tb.
+//
+// MustClose must be called when ts is no longer needed.
 func (ts *TableSearch) Init(tb *Table) {
+	if ts.needClosing {
+		panic("BUG: missing MustClose call before the next call to Init")
+	}
+	ts.reset()
 
 	ts.tb = tb
+	ts.needClosing = true
 	ts.pws = ts.tb.getParts(ts.pws[:0])
 
 	if cap(ts.psPool) < len(ts.pws) {
@@ -26,25 +56,246 @@ func (ts *TableSearch) Init(tb *Table) {
 
 }
 
+func (ts *TableSearch) reset() {
+	ts.tb = nil
+	ts.Item = nil
+	for i := range ts.pws {
+		ts.pws[i] = nil
+	}
+	ts.pws = ts.pws[:0]
+	for i := range ts.psPool {
+		ps := &ts.psPool[i]
+		ps.releaseBlock()
+		ps.p = nil
+	}
+	ts.psPool = ts.psPool[:0]
+	for i := range ts.psHeap {
+		ts.psHeap[i] = nil
+	}
+	ts.psHeap = ts.psHeap[:0]
+	ts.nextItemNoop = false
+	ts.prefix = ts.prefix[:0]
+	ts.end = ts.end[:0]
+	ts.err = nil
+	ts.needClosing = false
+}
+
+// MustClose releases the parts obtained in Init.
+func (ts *TableSearch) MustClose() {
+	if !ts.needClosing {
+		panic("BUG: missing Init call before MustClose")
+	}
+	for _, pw := range ts.pws {
+		pw.decRef()
+	}
+	ts.reset()
+}
+
+// Seek positions ts at the first item greater or equal to k.
+//
+// The found item is put into ts.Item. The following items are obtained with NextItem.
 func (ts *TableSearch) Seek(k []byte) {
-	ts.psHeap = ts.psHeap[:]
+	ts.seek(k, nil, false)
+}
+
+// SeekLE positions ts at the last item smaller or equal to k.
+//
+// The found item is put into ts.Item. NextItem returns the preceding items in descending order.
+func (ts *TableSearch) SeekLE(k []byte) {
+	ts.Item = nil
+	ts.err = nil
+	ts.nextItemNoop = false
+	ts.prefix = ts.prefix[:0]
+	ts.end = ts.end[:0]
+	ts.psHeap = ts.psHeap[:0]
+	for i := range ts.psPool {
+		ps := &ts.psPool[i]
+		if string(ps.p.ph.firstItem) > string(k) {
+			// All the items in the part are bigger than k.
+			continue
+		}
+		ps.SeekLE(k)
+		if !ps.NextItem() {
+			if err := ps.Error(); err != nil {
+				ts.err = fmt.Errorf("cannot seek %q in part %q: %w", k, ps.p.path, err)
+				return
+			}
+			continue
+		}
+		ts.psHeap = append(ts.psHeap, ps)
+	}
+
+	if len(ts.psHeap) == 0 {
+		ts.err = io.EOF
+		return
+	}
+	heap.Init(&ts.psHeap)
+	ts.Item = ts.psHeap[0].Item
+	ts.nextItemNoop = true
+}
 
+// SeekRange positions ts at the first item in the [start, end) range.
+//
+// NextItem stops at the first item greater or equal to end. Empty end means no upper bound.
+func (ts *TableSearch) SeekRange(start, end []byte) {
+	ts.seek(start, end, false)
+}
+
+// seek positions ts at the first item in the [k, end) range.
+//
+// The parts without items starting with k are skipped if isPrefix is set.
+func (ts *TableSearch) seek(k, end []byte, isPrefix bool) {
+	ts.Item = nil
+	ts.err = nil
+	ts.nextItemNoop = false
+	ts.prefix = ts.prefix[:0]
+	ts.end = append(ts.end[:0], end...)
 	ts.psHeap = ts.psHeap[:0]
-	for i, _ := range ts.psPool {
+	for i := range ts.psPool {
 		ps := &ts.psPool[i]
+		if isPrefix && !ps.p.hasItemsWithPrefix(k) {
+			continue
+		}
+		if !ps.p.hasItemsInRange(k, ts.end) {
+			continue
+		}
+		ps.end = ts.end
 		ps.Seek(k)
 
 		if !ps.NextItem() {
+			if err := ps.Error(); err != nil {
+				ts.err = fmt.Errorf("cannot seek %q in part %q: %w", k, ps.p.path, err)
+				return
+			}
 			continue
 		}
 		ts.psHeap = append(ts.psHeap, ps)
 	}
 
 	if len(ts.psHeap) == 0 {
+		ts.err = io.EOF
 		return
 	}
 	heap.Init(&ts.psHeap)
-	ts.Item = ts.psHeap[0].Item
+	if !ts.setItem(ts.psHeap[0].Item) {
+		return
+	}
 	ts.nextItemNoop = true
 
 }
+
+// NextItem advances ts to the next item in sorted order and puts it into ts.Item.
+// The items are returned in descending order after SeekLE.
+//
+// The first call after Seek or SeekLE returns the found item.
+// It returns false when there are no more items or on error, which is returned by Error.
+func (ts *TableSearch) NextItem() bool {
+	if ts.err != nil {
+		return false
+	}
+	if ts.nextItemNoop {
+		ts.nextItemNoop = false
+		return true
+	}
+	if len(ts.psHeap) == 0 {
+		// Seek wasn't called.
+		ts.err = io.EOF
+		return false
+	}
+
+	psMin := ts.psHeap[0]
+	if psMin.NextItem() {
+		heap.Fix(&ts.psHeap, 0)
+		return ts.setItem(ts.psHeap[0].Item)
+	}
+	if err := psMin.Error(); err != nil {
+		ts.err = fmt.Errorf("cannot obtain the next item from part %q: %w", psMin.p.path, err)
+		return false
+	}
+
+	heap.Pop(&ts.psHeap)
+	if len(ts.psHeap) == 0 {
+		ts.Item = nil
+		ts.err = io.EOF
+		return false
+	}
+	return ts.setItem(ts.psHeap[0].Item)
+}
+
+func (ts *TableSearch) setItem(item []byte) bool {
+	if len(ts.prefix) > 0 && !bytes.HasPrefix(item, ts.prefix) {
+		// The items with the prefix are over.
+		ts.Item = nil
+		ts.err = io.EOF
+		return false
+	}
+	if len(ts.end) > 0 && string(item) >= string(ts.end) {
+		// The items in the range are over.
+		ts.Item = nil
+		ts.err = io.EOF
+		return false
+	}
+	ts.Item = item
+	return true
+}
+
+// FirstItemWithPrefix puts the first item starting with prefix into ts.Item.
+//
+// The following items with the prefix are obtained with NextItem.
+// io.EOF is returned if there are no items with the prefix.
+func (ts *TableSearch) FirstItemWithPrefix(prefix []byte) error {
+	ts.seek(prefix, nil, true)
+	if !ts.NextItem() {
+		if err := ts.Error(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if !bytes.HasPrefix(ts.Item, prefix) {
+		ts.Item = nil
+		ts.err = io.EOF
+		return io.EOF
+	}
+	ts.prefix = append(ts.prefix[:0], prefix...)
+	return nil
+}
+
+// ContainsItem returns true if the table contains the given item.
+func (ts *TableSearch) ContainsItem(item []byte) (bool, error) {
+	err := ts.FirstItemWithPrefix(item)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(ts.Item) == string(item), nil
+}
+
+// Error returns the last error occurred in Seek or NextItem.
+func (ts *TableSearch) Error() error {
+	if ts.err == io.EOF {
+		return nil
+	}
+	return ts.err
+}
+
+// Scan calls fn for the items in the [start, end) range in sorted order. Empty end means no upper bound.
+//
+// The item passed to fn is valid only during the call. Scan stops when fn returns false.
+func (tb *Table) Scan(start, end []byte, fn func(item []byte) bool) error {
+	var ts TableSearch
+	ts.Init(tb)
+	defer ts.MustClose()
+
+	ts.SeekRange(start, end)
+	for ts.NextItem() {
+		if !fn(ts.Item) {
+			return nil
+		}
+	}
+	if err := ts.Error(); err != nil {
+		return fmt.Errorf("cannot scan items in the range [%q, %q): %w", start, end, err)
+	}
+	return nil
+}
@@ -2,16 +2,450 @@ package mergeset
 
 import (
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestTableSearch(t *testing.T) {
-
-	path := "TestTableSearchSerial"
-	tb := OpenTable(path)
+	path := t.TempDir()
+	copyTestDir(t, "TestTableSearchSerial", path)
+	tb, err := OpenTable(path, Options{})
+	if err != nil {
+		t.Fatalf("cannot open table: %s", err)
+	}
+	defer tb.MustClose()
 	var ts TableSearch
 	ts.Init(tb)
+	defer ts.MustClose()
 	ts.Seek([]byte("1234"))
-	fmt.Println(string(ts.Item))
+	if !ts.NextItem() {
+		t.Fatalf("cannot find the item for %q: %v", "1234", ts.Error())
+	}
+	item := string(ts.Item)
+	if item < "1234" {
+		t.Fatalf("the found item must be greater or equal to %q; got %q", "1234", item)
+	}
+	if ts.NextItem() && string(ts.Item) <= item {
+		t.Fatalf("the next item must be greater than %q; got %q", item, ts.Item)
+	}
+	if err := ts.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestTableSearchInMemoryParts(t *testing.T) {
+	tb := mustOpenTableNoMerges(t, t.TempDir(), Options{})
+	defer tb.MustClose()
+
+	const itemsCount = 3000
+	padding := strings.Repeat("x", 1000)
+	var items [][]byte
+	for i := 0; i < itemsCount; i++ {
+		items = append(items, []byte(fmt.Sprintf("item_%04d_%s", i, padding)))
+	}
+	tb.AddItems(items)
+
+	f := func() {
+		t.Helper()
+		var ts TableSearch
+		ts.Init(tb)
+		defer ts.MustClose()
+		for _, i := range []int{0, 1, 1234, itemsCount - 1} {
+			ts.Seek([]byte(fmt.Sprintf("item_%04d", i)))
+			if string(ts.Item) != string(items[i]) {
+				t.Fatalf("unexpected item found for item_%04d; got %.20q", i, ts.Item)
+			}
+		}
+	}
+
+	for _, pw := range tb.parts {
+		if pw.mp == nil {
+			t.Fatalf("unexpected file part before merging the in-memory parts")
+		}
+	}
+	f()
+
+	tb.mergeInMemoryParts(0, nil)
+	if len(tb.parts) != 1 || tb.parts[0].mp != nil {
+		t.Fatalf("expecting a single file part after merging the in-memory parts")
+	}
+	f()
+}
+
+func TestTableSearchRawItems(t *testing.T) {
+	f := func(name string, opts Options, flush func(tb *Table)) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			tb := mustOpenTable(t, t.TempDir(), opts)
+			defer tb.MustClose()
+			tb.AddItems([][]byte{[]byte("foo"), []byte("bar")})
+
+			found := func() bool {
+				var ts TableSearch
+				ts.Init(tb)
+				defer ts.MustClose()
+				ts.Seek([]byte("foo"))
+				return string(ts.Item) == "foo"
+			}
+			flush(tb)
+			if !found() {
+				t.Fatalf("cannot find the flushed item")
+			}
+		})
+	}
+
+	f("DebugFlush", Options{FlushInterval: time.Hour}, func(tb *Table) {
+		tb.DebugFlush()
+	})
+	f("flusher", Options{FlushInterval: 10 * time.Millisecond}, func(tb *Table) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			tb.partsLock.RLock()
+			n := len(tb.parts)
+			tb.partsLock.RUnlock()
+			if n > 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("the raw items weren't flushed in time")
+	})
+}
+
+// newTestTableWithParts returns a table with unique items spread among file parts and in-memory parts.
+//
+// The returned items are sorted.
+func newTestTableWithParts(t *testing.T) (*Table, []string) {
+	t.Helper()
+	tb := mustOpenTableNoMerges(t, t.TempDir(), Options{FlushInterval: time.Hour})
+
+	padding := strings.Repeat("x", 100)
+	perm := rand.Perm(1e5)
+	var sortedItems []string
+	addItems := func(n int) {
+		var items [][]byte
+		for i := 0; i < n; i++ {
+			item := fmt.Sprintf("item_%08d_%s", perm[len(sortedItems)], padding)
+			items = append(items, []byte(item))
+			sortedItems = append(sortedItems, item)
+		}
+		tb.AddItems(items)
+		tb.DebugFlush()
+	}
+	addItems(10000)
+	tb.mergeInMemoryParts(0, nil)
+	addItems(10000)
+	tb.mergeInMemoryParts(0, nil)
+	addItems(5000)
+	addItems(1)
+	sort.Strings(sortedItems)
+	return tb, sortedItems
+}
+
+func TestTableSearchNextItem(t *testing.T) {
+	tb, want := newTestTableWithParts(t)
+	defer tb.MustClose()
+
+	readItems := func(ts *TableSearch, k string) []string {
+		t.Helper()
+		var items []string
+		ts.Seek([]byte(k))
+		for ts.NextItem() {
+			items = append(items, string(ts.Item))
+		}
+		if err := ts.Error(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return items
+	}
+	checkItems := func(got, want []string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("unexpected number of items; got %d; want %d", len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("unexpected item #%d; got %.20q; want %.20q", i, got[i], want[i])
+			}
+		}
+	}
+
+	var ts TableSearch
+	ts.Init(tb)
+	checkItems(readItems(&ts, ""), want)
+
+	// Seek in the middle of the table.
+	k := want[len(want)/2]
+	checkItems(readItems(&ts, k[:len("item_00000000")]), want[len(want)/2:])
+	checkItems(readItems(&ts, k+"y"), want[len(want)/2+1:])
+
+	// Seek beyond the last item.
+	checkItems(readItems(&ts, "z"), nil)
+	ts.MustClose()
+
+	for _, pw := range tb.parts {
+		if pw.refCount != 1 {
+			t.Fatalf("unexpected refCount after MustClose; got %d; want 1", pw.refCount)
+		}
+	}
+}
+
+func TestTableSearchMergedAwayParts(t *testing.T) {
+	path := t.TempDir()
+	_, sortedItems := newTestFilePart(t, path, 3000)
+	srcPartPath, sortedItems2 := newTestFilePart(t, t.TempDir(), 3000)
+	if err := os.Rename(srcPartPath, filepath.Join(path, filepath.Base(srcPartPath))); err != nil {
+		t.Fatalf("cannot move the part: %s", err)
+	}
+	want := append(sortedItems, sortedItems2...)
+	sort.Strings(want)
+
+	tb := mustOpenTableNoMerges(t, path, Options{})
+	defer tb.MustClose()
+	pws := append([]*partWrapper{}, tb.parts...)
+
+	// The search must read the source parts while they are merged away.
+	var ts TableSearch
+	ts.Init(tb)
+	ts.Seek(nil)
+	if err := tb.mergeParts(pws, nil); err != nil {
+		t.Fatalf("cannot merge parts: %s", err)
+	}
+	txnDir := filepath.Join(path, "txn")
+	for _, pw := range pws {
+		if _, err := os.Stat(pw.p.path); err != nil {
+			t.Fatalf("the part %q must exist while it is used by the search: %s", pw.p.path, err)
+		}
+	}
+	if des, err := os.ReadDir(txnDir); err != nil || len(des) != 1 {
+		t.Fatalf("expecting a single pending transaction; got %d entries; err: %v", len(des), err)
+	}
+
+	var got []string
+	for ts.NextItem() {
+		got = append(got, string(ts.Item))
+	}
+	if err := ts.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected items read from the merged away parts")
+	}
+
+	ts.MustClose()
+	for _, pw := range pws {
+		if _, err := os.Stat(pw.p.path); !os.IsNotExist(err) {
+			t.Fatalf("the part %q must be removed after the search is closed; stat error: %v", pw.p.path, err)
+		}
+	}
+	if des, err := os.ReadDir(txnDir); err != nil || len(des) != 0 {
+		t.Fatalf("the transaction must be removed after the search is closed; got %d entries; err: %v", len(des), err)
+	}
+}
+
+func TestTableSearchFirstItemWithPrefix(t *testing.T) {
+	tb := mustOpenTableNoMerges(t, t.TempDir(), Options{FlushInterval: time.Hour})
+	defer tb.MustClose()
+
+	// Put the items with distinct prefixes into distinct parts, so some parts are pruned.
+	for _, items := range [][]string{
+		{"a1", "a2", "b1"},
+		{"b2", "b3", "c1"},
+		{"e1", "e2"},
+	} {
+		var ibs [][]byte
+		for _, item := range items {
+			ibs = append(ibs, []byte(item))
+		}
+		tb.AddItems(ibs)
+		tb.DebugFlush()
+	}
+	tb.mergeInMemoryParts(0, nil)
+	tb.AddItems([][]byte{[]byte("b0"), []byte("d1")})
+	tb.DebugFlush()
+
+	var ts TableSearch
+	ts.Init(tb)
+	defer ts.MustClose()
+
+	f := func(prefix string, want []string) {
+		t.Helper()
+		err := ts.FirstItemWithPrefix([]byte(prefix))
+		if len(want) == 0 {
+			if err != io.EOF {
+				t.Fatalf("expecting io.EOF for prefix %q; got %v", prefix, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for prefix %q: %s", prefix, err)
+		}
+		got := []string{string(ts.Item)}
+		for ts.NextItem() {
+			got = append(got, string(ts.Item))
+		}
+		if err := ts.Error(); err != nil {
+			t.Fatalf("unexpected error for prefix %q: %s", prefix, err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("unexpected items for prefix %q; got %q; want %q", prefix, got, want)
+		}
+	}
+
+	f("a", []string{"a1", "a2"})
+	f("b", []string{"b0", "b1", "b2", "b3"})
+	f("b2", []string{"b2"})
+	f("d", []string{"d1"})
+	f("", []string{"a1", "a2", "b0", "b1", "b2", "b3", "c1", "d1", "e1", "e2"})
+	f("0", nil)
+	f("bb", nil)
+	f("c2", nil)
+	f("f", nil)
+
+	g := func(item string, want bool) {
+		t.Helper()
+		ok, err := ts.ContainsItem([]byte(item))
+		if err != nil {
+			t.Fatalf("unexpected error for item %q: %s", item, err)
+		}
+		if ok != want {
+			t.Fatalf("unexpected ContainsItem result for item %q; got %v; want %v", item, ok, want)
+		}
+	}
+
+	g("a1", true)
+	g("b0", true)
+	g("e2", true)
+	g("a", false)
+	g("b", false)
+	g("b31", false)
+	g("z", false)
+}
+
+func TestTableScan(t *testing.T) {
+	tb := mustOpenTableNoMerges(t, t.TempDir(), Options{FlushInterval: time.Hour})
+	defer tb.MustClose()
+
+	// Create file parts with distinct ranges and an in-memory part overlapping all of them.
+	padding := strings.Repeat("x", 100)
+	var all []string
+	for i := 0; i < 4; i++ {
+		var items [][]byte
+		for j := 0; j < 5000; j++ {
+			item := fmt.Sprintf("item_%d_%05d_%s", i, j*2, padding)
+			items = append(items, []byte(item))
+			all = append(all, item)
+		}
+		tb.AddItems(items)
+		tb.mergeInMemoryParts(0, nil)
+	}
+	var items [][]byte
+	for i := 0; i < 4; i++ {
+		item := fmt.Sprintf("item_%d_%05d_%s", i, 1, padding)
+		items = append(items, []byte(item))
+		all = append(all, item)
+	}
+	tb.AddItems(items)
+	tb.DebugFlush()
+	sort.Strings(all)
+
+	f := func(start, end string, limit int) {
+		t.Helper()
+		var want []string
+		for _, item := range all {
+			if item >= start && (end == "" || item < end) {
+				want = append(want, item)
+			}
+		}
+		if limit > 0 && len(want) > limit {
+			want = want[:limit]
+		}
+
+		var got []string
+		err := tb.Scan([]byte(start), []byte(end), func(item []byte) bool {
+			got = append(got, string(item))
+			return limit <= 0 || len(got) < limit
+		})
+		if err != nil {
+			t.Fatalf("unexpected error for the range [%q, %q): %s", start, end, err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("unexpected items for the range [%q, %q); got %d items; want %d items", start, end, len(got), len(want))
+		}
+	}
+
+	f("", "", 0)
+	f("item_1", "item_2", 0)
+	f("item_1_00100", "item_1_00200", 0)
+	f("item_0_09998", "item_1_00002", 0)
+	f("item_2_00001", "item_2_00002", 0)
+	f("item_2", "item_2", 0)
+	f("item_3", "item_1", 0)
+	f("item_3_09999", "", 0)
+	f("item_9", "", 0)
+	f("", "item_0", 0)
+	f("item_1", "", 10)
+	f("item_1", "item_3", 1)
+}
+
+func TestTableSearchSeekLE(t *testing.T) {
+	tb, all := newTestTableWithParts(t)
+	defer tb.MustClose()
+
+	var ts TableSearch
+	ts.Init(tb)
+	defer ts.MustClose()
+
+	f := func(k string, limit int) {
+		t.Helper()
+		n := sort.Search(len(all), func(i int) bool {
+			return all[i] > k
+		})
+		var want []string
+		for i := n - 1; i >= 0 && len(want) < limit; i-- {
+			want = append(want, all[i])
+		}
+
+		var got []string
+		ts.SeekLE([]byte(k))
+		for len(got) < limit && ts.NextItem() {
+			got = append(got, string(ts.Item))
+		}
+		if err := ts.Error(); err != nil {
+			t.Fatalf("unexpected error for %q: %s", k, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("unexpected number of items for %q; got %d; want %d", k, len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("unexpected item #%d for %q; got %.20q; want %.20q", i, k, got[i], want[i])
+			}
+		}
+	}
+
+	f("z", len(all))
+	f(all[len(all)-1], 10)
+	f(all[len(all)/2], 1000)
+	f(all[len(all)/2][:len("item_00000000")], 1000)
+	f(all[0], 10)
+	f("item_", 10)
+	f("", 10)
+
+	// The latest items with the prefix.
+	f("item_0001\xff", 5)
 
+	// Seek must return the items in ascending order after SeekLE.
+	ts.Seek([]byte(all[1]))
+	for _, want := range all[1:4] {
+		if !ts.NextItem() || string(ts.Item) != want {
+			t.Fatalf("unexpected item after Seek; got %.20q; want %.20q", ts.Item, want)
+		}
+	}
 }
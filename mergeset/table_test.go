@@ -1,24 +1,476 @@
 package mergeset
 
 import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
+// copyTestDir copies the files from the src directory tree to dst.
+//
+// It is used for opening the test tables without modifying them.
+func copyTestDir(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, relPath)
+		if fi.IsDir() {
+			return os.MkdirAll(dstPath, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dstPath, data, 0644)
+	})
+	if err != nil {
+		t.Fatalf("cannot copy %q to %q: %s", src, dst, err)
+	}
+}
+
+func mustOpenTable(t *testing.T, path string, opts Options) *Table {
+	t.Helper()
+	tb, err := OpenTable(path, opts)
+	if err != nil {
+		t.Fatalf("cannot open table: %s", err)
+	}
+	return tb
+}
+
+func mustOpenTableNoMerges(t *testing.T, path string, opts Options) *Table {
+	t.Helper()
+	tb, err := openTable(path, opts)
+	if err != nil {
+		t.Fatalf("cannot open table: %s", err)
+	}
+	return tb
+}
+
 func TestOpenTable(t *testing.T) {
-	path := "TestTableSearchSerial3"
-	OpenTable(path)
+	path := t.TempDir()
+	copyTestDir(t, "TestTableSearchSerial3", path)
+	tb, err := OpenTable(path, Options{})
+	if err != nil {
+		t.Fatalf("cannot open table: %s", err)
+	}
+	tb.MustClose()
+}
+
+func TestOpenTableBrokenPart(t *testing.T) {
+	path := t.TempDir()
+	partPath, _ := newTestFilePart(t, path, 1000)
+	if err := os.Remove(filepath.Join(partPath, "items.bin")); err != nil {
+		t.Fatalf("cannot remove items.bin: %s", err)
+	}
 
+	_, err := OpenTable(path, Options{})
+	if err == nil {
+		t.Fatalf("expecting an error when opening the table with the broken part")
+	}
+	if !strings.Contains(err.Error(), "items.bin") || !strings.Contains(err.Error(), filepath.Base(partPath)) {
+		t.Fatalf("the error must name the part and the file at fault; got %q", err)
+	}
+
+	tb := mustOpenTable(t, path, Options{QuarantineBrokenParts: true})
+	defer tb.MustClose()
+	if len(tb.parts) != 0 {
+		t.Fatalf("unexpected number of parts; got %d; want 0", len(tb.parts))
+	}
+	brokenPath := filepath.Join(path, "broken", filepath.Base(partPath))
+	if _, err := os.Stat(brokenPath); err != nil {
+		t.Fatalf("the broken part must be moved to %q: %s", brokenPath, err)
+	}
 }
 
 func TestAddItems(t *testing.T) {
-	path := "TestTableSearchSerial3"
-	table := OpenTable(path)
+	path := t.TempDir()
+	copyTestDir(t, "TestTableSearchSerial3", path)
+	table := mustOpenTable(t, path, Options{})
+	defer table.MustClose()
 	//fmt.Println(table.parts[0].p)
 	for i := 0; i < 1e5; i++ {
 		table.AddItems([][]byte{
-			[]byte("key"+strconv.Itoa(i)),
+			[]byte("key" + strconv.Itoa(i)),
+		})
+	}
+
+}
+
+func TestTableMergeRawItems(t *testing.T) {
+	path := t.TempDir()
+	tb := mustOpenTableNoMerges(t, path, Options{})
+
+	// Add the items in reverse order to a single shard, so it is flushed
+	// after reaching MaxBlocksPerShard blocks.
+	const itemsCount = 2000
+	padding := strings.Repeat("x", 1000)
+	var items [][]byte
+	for i := itemsCount - 1; i >= 0; i-- {
+		items = append(items, []byte(fmt.Sprintf("item_%04d_%s", i, padding)))
+	}
+	tb.AddItems(items)
+	tb.mergeInMemoryParts(0, nil)
+
+	if len(tb.parts) != 1 {
+		t.Fatalf("unexpected number of parts after the flush; got %d; want 1", len(tb.parts))
+	}
+	if tb.parts[0].mp != nil {
+		t.Fatalf("the flushed part must be a file part")
+	}
+	tb.MustClose()
+
+	// The merged part must be loaded on open.
+	tb = mustOpenTableNoMerges(t, path, Options{})
+	defer tb.MustClose()
+	if len(tb.parts) != 1 {
+		t.Fatalf("unexpected number of parts after reopening; got %d; want 1", len(tb.parts))
+	}
+	ph := &tb.parts[0].p.ph
+	if ph.itemsCount != itemsCount {
+		t.Fatalf("unexpected itemsCount; got %d; want %d", ph.itemsCount, itemsCount)
+	}
+	if want := fmt.Sprintf("item_0000_%s", padding); string(ph.firstItem) != want {
+		t.Fatalf("unexpected firstItem; got %q; want %q", ph.firstItem, want)
+	}
+	if want := fmt.Sprintf("item_%04d_%s", itemsCount-1, padding); string(ph.lastItem) != want {
+		t.Fatalf("unexpected lastItem; got %q; want %q", ph.lastItem, want)
+	}
+}
+
+func TestTableInMemoryPartsFlush(t *testing.T) {
+	path := t.TempDir()
+	tb := mustOpenTable(t, path, Options{
+		FlushInterval:              time.Hour,
+		inMemoryPartsFlushInterval: 10 * time.Millisecond,
+	})
+	defer tb.MustClose()
+
+	// A few in-memory parts must be flushed to disk without waiting for MaxInMemoryParts parts.
+	var want []string
+	for i := 0; i < 3; i++ {
+		item := fmt.Sprintf("item_%d", i)
+		tb.AddItems([][]byte{[]byte(item)})
+		tb.DebugFlush()
+		want = append(want, item)
+	}
+
+	hasInMemoryParts := func() bool {
+		tb.partsLock.RLock()
+		defer tb.partsLock.RUnlock()
+		for _, pw := range tb.parts {
+			if pw.mp != nil {
+				return true
+			}
+		}
+		return len(tb.parts) == 0
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for hasInMemoryParts() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the in-memory parts weren't flushed to disk in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Check the flushed items in a copy of the table, which is still open.
+	copyPath := t.TempDir()
+	copyTestDir(t, path, copyPath)
+	tb2 := mustOpenTableNoMerges(t, copyPath, Options{})
+	defer tb2.MustClose()
+	var got []string
+	if err := tb2.Scan(nil, nil, func(item []byte) bool {
+		got = append(got, string(item))
+		return true
+	}); err != nil {
+		t.Fatalf("cannot scan items: %s", err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected items on disk; got %q; want %q", got, want)
+	}
+}
+
+func TestTableMergeFileParts(t *testing.T) {
+	path := t.TempDir()
+	_, sortedItems := newTestFilePart(t, path, 3000)
+
+	// Move the part of another table into path.
+	srcPath, sortedItems2 := newTestFilePart(t, t.TempDir(), 3000)
+	if err := os.Rename(srcPath, filepath.Join(path, filepath.Base(srcPath))); err != nil {
+		t.Fatalf("cannot move the part: %s", err)
+	}
+
+	tb := mustOpenTableNoMerges(t, path, Options{})
+	if len(tb.parts) != 2 {
+		t.Fatalf("unexpected number of parts; got %d; want 2", len(tb.parts))
+	}
+	pws := append([]*partWrapper{}, tb.parts...)
+
+	if err := tb.mergeParts(pws, nil); err != nil {
+		t.Fatalf("cannot merge file parts: %s", err)
+	}
+	for _, pw := range pws {
+		if _, err := os.Stat(pw.p.path); !os.IsNotExist(err) {
+			t.Fatalf("the merged part %q must be removed; stat error: %v", pw.p.path, err)
+		}
+	}
+	tb.MustClose()
+
+	tb = mustOpenTableNoMerges(t, path, Options{})
+	defer tb.MustClose()
+	if len(tb.parts) != 1 {
+		t.Fatalf("unexpected number of parts after the merge; got %d; want 1", len(tb.parts))
+	}
+	var bsr blockStreamReader
+	if err := bsr.InitFromFilePart(tb.parts[0].p.path); err != nil {
+		t.Fatalf("cannot open block stream reader: %s", err)
+	}
+	defer bsr.MustClose()
+	items, err := readBlockStreamItems(&bsr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := append(sortedItems, sortedItems2...)
+	sort.Strings(want)
+	if strings.Join(items, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected items after merging file parts")
+	}
+}
+
+func TestTableMustClose(t *testing.T) {
+	path := t.TempDir()
+	tb := mustOpenTable(t, path, Options{FlushInterval: time.Hour})
+
+	padding := strings.Repeat("x", 100)
+	var want []string
+	addItems := func(n int) {
+		var items [][]byte
+		for i := 0; i < n; i++ {
+			item := fmt.Sprintf("item_%08d_%s", rand.Intn(1e8), padding)
+			items = append(items, []byte(item))
+			want = append(want, item)
+		}
+		tb.AddItems(items)
+	}
+
+	// Create a file part, in-memory parts and raw items.
+	addItems(20000)
+	tb.mergeInMemoryParts(0, nil)
+	addItems(20000)
+	addItems(100)
+	tb.DebugFlush()
+	addItems(100)
+	addItems(1)
+	tb.MustClose()
+	sort.Strings(want)
+
+	checkTableItems(t, path, want)
+}
+
+// checkTableItems verifies that the file parts of the table at path contain the sorted items from want.
+func checkTableItems(t *testing.T, path string, want []string) {
+	t.Helper()
+	tb := mustOpenTableNoMerges(t, path, Options{})
+	defer tb.MustClose()
+	var got []string
+	for _, pw := range tb.parts {
+		var bsr blockStreamReader
+		if err := bsr.InitFromFilePart(pw.p.path); err != nil {
+			t.Fatalf("cannot open block stream reader: %s", err)
+		}
+		items, err := readBlockStreamItems(&bsr)
+		bsr.MustClose()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got = append(got, items...)
+	}
+	sort.Strings(got)
+	if len(got) != len(want) {
+		t.Fatalf("unexpected number of items after reopening; got %d; want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected item #%d after reopening; got %.20q; want %.20q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestAppendPartsToMerge(t *testing.T) {
+	f := func(sizes []uint64, maxPartsToMerge int, maxOutBytes uint64, expectedSizes []uint64) {
+		t.Helper()
+		var src []*partWrapper
+		for _, size := range sizes {
+			src = append(src, &partWrapper{
+				p: &part{size: size},
+			})
+		}
+		pws := appendPartsToMerge(nil, src, maxPartsToMerge, maxOutBytes)
+		var gotSizes []uint64
+		for _, pw := range pws {
+			gotSizes = append(gotSizes, pw.p.size)
+		}
+		if fmt.Sprint(gotSizes) != fmt.Sprint(expectedSizes) {
+			t.Fatalf("unexpected parts to merge for sizes %v; got %v; want %v", sizes, gotSizes, expectedSizes)
+		}
+	}
+
+	f(nil, 15, 1e9, nil)
+	f([]uint64{1}, 15, 1e9, nil)
+
+	// Too few parts for merging.
+	f([]uint64{1, 1, 1, 1, 1}, 15, 1e9, nil)
+	f([]uint64{1, 1}, 2, 1e9, []uint64{1, 1})
+
+	// Similar parts are merged.
+	f([]uint64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 15, 1e9, []uint64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
+	f([]uint64{2, 1, 2, 1, 2, 1, 2, 1, 2, 1}, 15, 1e9, []uint64{1, 1, 1, 1, 1, 2, 2, 2, 2, 2})
+
+	// Parts with too small size ratio aren't merged because of high write amplification.
+	f([]uint64{4, 2, 3, 1, 2, 3, 4, 1, 2, 3}, 15, 1e9, nil)
+
+	// The big part isn't merged with the small parts.
+	f([]uint64{1, 1, 1, 1, 1000, 1, 1, 1, 1}, 15, 1e9, []uint64{1, 1, 1, 1, 1, 1, 1, 1})
+
+	// At most maxPartsToMerge parts are merged.
+	f([]uint64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 4, 1e9, []uint64{1, 1, 1, 1})
+
+	// The merged part cannot exceed maxOutBytes.
+	f([]uint64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10}, 15, 50, nil)
+	f([]uint64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10}, 4, 50, []uint64{10, 10, 10, 10})
+}
+
+func TestTableBackgroundMerge(t *testing.T) {
+	path := t.TempDir()
+	tb := mustOpenTable(t, path, Options{FlushInterval: time.Hour})
+
+	// Create many small parts, which must be merged by the merge workers.
+	const partsCount = 50
+	var want []string
+	for i := 0; i < partsCount; i++ {
+		var items [][]byte
+		for j := 0; j < 100; j++ {
+			item := fmt.Sprintf("item_%08d", rand.Intn(1e8))
+			items = append(items, []byte(item))
+			want = append(want, item)
+		}
+		tb.AddItems(items)
+		tb.DebugFlush()
+	}
+
+	partsCountAfterMerge := func() int {
+		tb.partsLock.RLock()
+		defer tb.partsLock.RUnlock()
+		return len(tb.parts)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for partsCountAfterMerge() >= partsCount {
+		if time.Now().After(deadline) {
+			t.Fatalf("the parts weren't merged in time; parts count: %d", partsCountAfterMerge())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.MustClose()
+
+	sort.Strings(want)
+	checkTableItems(t, path, want)
+}
+
+func TestTableTransactionRecovery(t *testing.T) {
+	// Prepare the source parts and the part obtained by merging them.
+	srcPath := t.TempDir()
+	_, sortedItems := newTestFilePart(t, srcPath, 3000)
+	srcPartPath2, sortedItems2 := newTestFilePart(t, t.TempDir(), 3000)
+	if err := os.Rename(srcPartPath2, filepath.Join(srcPath, filepath.Base(srcPartPath2))); err != nil {
+		t.Fatalf("cannot move the part: %s", err)
+	}
+	want := append(sortedItems, sortedItems2...)
+	sort.Strings(want)
+
+	mergedPath := t.TempDir()
+	copyTestDir(t, srcPath, mergedPath)
+	tb := mustOpenTableNoMerges(t, mergedPath, Options{})
+	if err := tb.mergeParts(append([]*partWrapper{}, tb.parts...), nil); err != nil {
+		t.Fatalf("cannot merge parts: %s", err)
+	}
+	mergedPartName := filepath.Base(tb.parts[0].p.path)
+	tb.MustClose()
+
+	var srcPartNames []string
+	des, err := os.ReadDir(srcPath)
+	if err != nil {
+		t.Fatalf("cannot read %q: %s", srcPath, err)
+	}
+	for _, de := range des {
+		if name := de.Name(); name != "tmp" && name != "txn" {
+			srcPartNames = append(srcPartNames, name)
+		}
+	}
+	if len(srcPartNames) != 2 {
+		t.Fatalf("unexpected number of source parts; got %d; want 2", len(srcPartNames))
+	}
+
+	// Simulate a crash in the middle of the transaction, which replaces the source parts with the merged part.
+	f := func(name string, prepare func(path string)) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			path := t.TempDir()
+			copyTestDir(t, srcPath, path)
+			copyTestDir(t, filepath.Join(mergedPath, mergedPartName), filepath.Join(path, "tmp", "0000000000000001"))
+			txn := fmt.Sprintf("%s\n%s\ntmp/0000000000000001 -> %s\n", srcPartNames[0], srcPartNames[1], mergedPartName)
+			if err := os.WriteFile(filepath.Join(path, "txn", "0000000000000001"), []byte(txn), 0644); err != nil {
+				t.Fatalf("cannot write transaction: %s", err)
+			}
+			// The interrupted merge without the transaction must be dropped.
+			copyTestDir(t, filepath.Join(mergedPath, mergedPartName), filepath.Join(path, "tmp", "0000000000000002"))
+			if err := os.WriteFile(filepath.Join(path, "txn", "0000000000000002.tmp"), []byte("foo"), 0644); err != nil {
+				t.Fatalf("cannot write incomplete transaction: %s", err)
+			}
+			prepare(path)
+
+			tb := mustOpenTableNoMerges(t, path, Options{})
+			if len(tb.parts) != 1 || filepath.Base(tb.parts[0].p.path) != mergedPartName {
+				t.Fatalf("expecting a single merged part %q after the recovery", mergedPartName)
+			}
+			tb.MustClose()
+			for _, dir := range []string{"tmp", "txn"} {
+				des, err := os.ReadDir(filepath.Join(path, dir))
+				if err != nil {
+					t.Fatalf("cannot read %q: %s", dir, err)
+				}
+				if len(des) > 0 {
+					t.Fatalf("unexpected entries left in %q: %d", dir, len(des))
+				}
+			}
+			checkTableItems(t, path, want)
 		})
 	}
 
-}
\ No newline at end of file
+	f("NotStarted", func(path string) {})
+	f("SourcePartRemoved", func(path string) {
+		if err := os.RemoveAll(filepath.Join(path, srcPartNames[0])); err != nil {
+			t.Fatalf("cannot remove the source part: %s", err)
+		}
+	})
+	f("MergedPartMoved", func(path string) {
+		for _, name := range srcPartNames {
+			if err := os.RemoveAll(filepath.Join(path, name)); err != nil {
+				t.Fatalf("cannot remove the source part: %s", err)
+			}
+		}
+		if err := os.Rename(filepath.Join(path, "tmp", "0000000000000001"), filepath.Join(path, mergedPartName)); err != nil {
+			t.Fatalf("cannot move the merged part: %s", err)
+		}
+	})
+}